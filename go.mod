@@ -5,16 +5,16 @@ go 1.20
 require (
 	github.com/bitrise-io/go-steputils/v2 v2.0.0-alpha.42
 	github.com/bitrise-io/go-utils/v2 v2.0.0-alpha.26
+	github.com/bmatcuk/doublestar/v4 v4.6.1
+	github.com/docker/go-units v0.5.0
+	github.com/hashicorp/go-retryablehttp v0.7.7
+	github.com/klauspost/compress v1.17.8
 )
 
 require (
 	github.com/bitrise-io/go-utils v1.0.13 // indirect
 	github.com/bitrise-io/got v0.0.0-20240902113940-25f6469d1456 // indirect
-	github.com/bmatcuk/doublestar/v4 v4.6.1 // indirect
-	github.com/docker/go-units v0.5.0 // indirect
 	github.com/gofrs/uuid/v5 v5.2.0 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
-	github.com/hashicorp/go-retryablehttp v0.7.7 // indirect
-	github.com/klauspost/compress v1.17.8 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 )
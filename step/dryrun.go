@@ -0,0 +1,128 @@
+package step
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/keytemplate"
+	"github.com/docker/go-units"
+)
+
+const dryRunResultOutputKey = "BITRISE_CACHE_DRY_RUN_RESULT"
+
+type dryRunResult struct {
+	// Name and Error are only set in the reports of the caches input
+	Name           string      `json:"name,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	Key            string      `json:"key"`
+	Paths          []pathStats `json:"paths"`
+	TotalFileCount int         `json:"total_file_count"`
+	TotalSizeBytes int64       `json:"total_size_bytes"`
+	CanSkipSave    bool        `json:"can_skip_save"`
+	SkipReason     string      `json:"skip_reason"`
+}
+
+type pathStats struct {
+	Path      string `json:"path"`
+	FileCount int    `json:"file_count"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// dryRun evaluates the key and the paths, and predicts whether the cache saving would be skipped,
+// without creating and uploading the archive.
+func (step SaveCacheStep) dryRun(input Input, paths []string) (dryRunResult, error) {
+	step.logger.Println()
+	step.logger.Infof("Dry run: the cache archive won't be created and uploaded")
+
+	keyTemplate, allowed, guardReason := step.guardBranch(input)
+	input.Key = keyTemplate
+	if input.StrictKey {
+		if err := step.validateKeyStrictly(input.Key); err != nil {
+			return dryRunResult{}, err
+		}
+	}
+
+	step.logger.Printf("Evaluating key template: %s", input.Key)
+	evaluatedKey, err := keytemplate.NewModel(step.envRepo, step.logger).Evaluate(input.Key)
+	if err != nil {
+		return dryRunResult{}, fmt.Errorf("failed to evaluate key template: %w", err)
+	}
+	step.logger.Donef("Cache key: %s", evaluatedKey)
+
+	result := dryRunResult{Key: evaluatedKey}
+
+	step.logger.Println()
+	step.logger.Infof("Paths to cache:")
+	for _, path := range paths {
+		stats, err := collectPathStats(path)
+		if err != nil {
+			return dryRunResult{}, fmt.Errorf("failed to collect stats of %s: %w", path, err)
+		}
+		step.logger.Printf("- %s (%d files, %s)", stats.Path, stats.FileCount, units.HumanSizeWithPrecision(float64(stats.SizeBytes), 3))
+
+		result.Paths = append(result.Paths, stats)
+		result.TotalFileCount += stats.FileCount
+		result.TotalSizeBytes += stats.SizeBytes
+	}
+	step.logger.Printf("Total: %d files, %s", result.TotalFileCount, units.HumanSizeWithPrecision(float64(result.TotalSizeBytes), 3))
+
+	if input.SecretScan != secretScanOff {
+		// Report the findings without failing the dry run
+		if err := step.scanSecrets(secretScanWarn, paths); err != nil {
+			return dryRunResult{}, err
+		}
+	}
+
+	canSkipSave, reason := step.canSkipSave(input.Key, evaluatedKey, input.IsKeyUnique)
+	if !allowed {
+		canSkipSave, reason = true, guardReason
+	}
+	result.CanSkipSave = canSkipSave
+	result.SkipReason = reason.String()
+
+	step.logger.Println()
+	if canSkipSave {
+		step.logger.Donef("Cache save would be skipped, reason: %s", reason.description())
+	} else {
+		step.logger.Infof("Cache save wouldn't be skipped, reason: %s", reason.description())
+	}
+
+	return result, nil
+}
+
+// exportDryRunResult exports the dry run result as JSON. The value can be a single result or a list of results.
+func (step SaveCacheStep) exportDryRunResult(result interface{}) error {
+	output, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode dry run result: %w", err)
+	}
+	if err := step.exporter.ExportOutputNoExpand(dryRunResultOutputKey, string(output)); err != nil {
+		return fmt.Errorf("failed to export %s: %w", dryRunResultOutputKey, err)
+	}
+	return nil
+}
+
+// collectPathStats counts the files and their total size under the path. Symlinks are not followed.
+func collectPathStats(path string) (pathStats, error) {
+	stats := pathStats{Path: path}
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		stats.FileCount++
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			stats.SizeBytes += info.Size()
+		}
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,92 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const (
+	prPolicyAllow     = "allow"
+	prPolicySkip      = "skip"
+	prPolicyNamespace = "namespace"
+)
+
+const (
+	gitBranchEnvKey   = "BITRISE_GIT_BRANCH"
+	prEnvKey          = "PR"
+	pullRequestEnvKey = "BITRISE_PULL_REQUEST"
+)
+
+// guardBranch protects the caches of trusted builds from being overwritten by pull request builds and untrusted branches.
+// It returns the key template to use, or false and the skip reason if the build is not allowed to save the cache.
+func (step SaveCacheStep) guardBranch(input Input) (string, bool, skipReason) {
+	patterns := parseBranchPatterns(input.AllowedBranches)
+
+	if step.isPullRequest() {
+		switch input.PRPolicy {
+		case prPolicySkip:
+			return input.Key, false, reasonPullRequest
+		case prPolicyNamespace:
+			keyTemplate := step.pullRequestKeyPrefix() + input.Key
+			step.logger.Printf("Pull request build, saving the cache with a pull request specific key: %s", keyTemplate)
+			// Namespaced keys are never restored by other builds, so the branch doesn't need to be allowed
+			return keyTemplate, true, 0
+		}
+		if len(patterns) > 0 {
+			// The branch of a pull request build is its source branch, which is named by the pull request's author
+			// (a fork's main branch is called main too), so it can't be trusted to match the allowed branches
+			step.logger.Printf("Pull request build, the source branch is not matched against the allowed branches")
+			return input.Key, false, reasonPullRequest
+		}
+	}
+
+	if len(patterns) == 0 {
+		return input.Key, true, 0
+	}
+	branch := step.envRepo.Get(gitBranchEnvKey)
+	if branch == "" {
+		step.logger.Warnf("Allowed branches are set, but the branch of the build is unknown (%s is empty)", gitBranchEnvKey)
+		return input.Key, false, reasonBranchNotAllowed
+	}
+	for _, pattern := range patterns {
+		if match, err := doublestar.Match(pattern, branch); err == nil && match {
+			step.logger.Debugf("Branch %s matches the allowed branch pattern %s", branch, pattern)
+			return input.Key, true, 0
+		}
+	}
+	step.logger.Printf("Branch %s doesn't match any of the allowed branches: %s", branch, strings.Join(patterns, ", "))
+	return input.Key, false, reasonBranchNotAllowed
+}
+
+func (step SaveCacheStep) isPullRequest() bool {
+	return step.envRepo.Get(prEnvKey) == "true" || step.envRepo.Get(pullRequestEnvKey) != ""
+}
+
+func (step SaveCacheStep) pullRequestKeyPrefix() string {
+	if id := step.envRepo.Get(pullRequestEnvKey); id != "" {
+		return fmt.Sprintf("pr-%s-", id)
+	}
+	return "pr-"
+}
+
+// parseBranchPatterns splits the multi-line or comma separated `allowed_branches` input.
+func parseBranchPatterns(input string) []string {
+	var patterns []string
+	for _, line := range strings.Split(strings.ReplaceAll(input, ",", "\n"), "\n") {
+		if pattern := strings.TrimSpace(line); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func validateBranchPatterns(input string) error {
+	for _, pattern := range parseBranchPatterns(input) {
+		if !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid allowed branch pattern: %s", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package step
+
+import (
+	"testing"
+)
+
+// mapEnvRepository is an env.Repository backed by a map, isolated from the process environment.
+type mapEnvRepository map[string]string
+
+func (r mapEnvRepository) List() []string {
+	var envs []string
+	for key, value := range r {
+		envs = append(envs, key+"="+value)
+	}
+	return envs
+}
+
+func (r mapEnvRepository) Unset(key string) error {
+	delete(r, key)
+	return nil
+}
+
+func (r mapEnvRepository) Get(key string) string {
+	return r[key]
+}
+
+func (r mapEnvRepository) Set(key, value string) error {
+	r[key] = value
+	return nil
+}
+
+func TestGuardBranch(t *testing.T) {
+	tests := []struct {
+		name            string
+		envs            map[string]string
+		allowedBranches string
+		prPolicy        string
+		wantKey         string
+		wantAllowed     bool
+		wantReason      skipReason
+	}{
+		{
+			name:        "no restrictions",
+			envs:        map[string]string{gitBranchEnvKey: "feature"},
+			prPolicy:    prPolicyAllow,
+			wantKey:     "key",
+			wantAllowed: true,
+		},
+		{
+			name:            "allowed branch",
+			envs:            map[string]string{gitBranchEnvKey: "release/1.0"},
+			allowedBranches: "main\nrelease/**",
+			prPolicy:        prPolicyAllow,
+			wantKey:         "key",
+			wantAllowed:     true,
+		},
+		{
+			name:            "not allowed branch",
+			envs:            map[string]string{gitBranchEnvKey: "feature"},
+			allowedBranches: "main, release/**",
+			prPolicy:        prPolicyAllow,
+			wantKey:         "key",
+			wantReason:      reasonBranchNotAllowed,
+		},
+		{
+			name:            "unknown branch",
+			allowedBranches: "main",
+			prPolicy:        prPolicyAllow,
+			wantKey:         "key",
+			wantReason:      reasonBranchNotAllowed,
+		},
+		{
+			name:        "pull request allowed without allowed branches",
+			envs:        map[string]string{gitBranchEnvKey: "feature", prEnvKey: "true"},
+			prPolicy:    prPolicyAllow,
+			wantKey:     "key",
+			wantAllowed: true,
+		},
+		{
+			name:            "pull request from a source branch named like an allowed branch",
+			envs:            map[string]string{gitBranchEnvKey: "main", pullRequestEnvKey: "12"},
+			allowedBranches: "main",
+			prPolicy:        prPolicyAllow,
+			wantKey:         "key",
+			wantReason:      reasonPullRequest,
+		},
+		{
+			name:       "pull request skipped",
+			envs:       map[string]string{gitBranchEnvKey: "feature", prEnvKey: "true"},
+			prPolicy:   prPolicySkip,
+			wantKey:    "key",
+			wantReason: reasonPullRequest,
+		},
+		{
+			name:            "pull request namespaced",
+			envs:            map[string]string{gitBranchEnvKey: "main", prEnvKey: "true", pullRequestEnvKey: "12"},
+			allowedBranches: "main",
+			prPolicy:        prPolicyNamespace,
+			wantKey:         "pr-12-key",
+			wantAllowed:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := newTestStep()
+			step.envRepo = mapEnvRepository(tt.envs)
+			if tt.envs == nil {
+				step.envRepo = mapEnvRepository{}
+			}
+
+			key, allowed, reason := step.guardBranch(Input{Key: "key", AllowedBranches: tt.allowedBranches, PRPolicy: tt.prPolicy})
+			if key != tt.wantKey || allowed != tt.wantAllowed {
+				t.Errorf("guardBranch() = %s, %t, want %s, %t", key, allowed, tt.wantKey, tt.wantAllowed)
+			}
+			if !allowed && reason != tt.wantReason {
+				t.Errorf("guardBranch() skip reason = %s, want %s", reason, tt.wantReason)
+			}
+		})
+	}
+}
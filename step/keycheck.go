@@ -0,0 +1,24 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keys longer than this are truncated by the Bitrise Build Cache API
+const maxKeyLength = 512
+
+// checkKey validates the evaluated key before the archive is created. The storage backends reject keys containing
+// commas, but only when uploading, after the time consuming compression.
+func (step SaveCacheStep) checkKey(evaluatedKey, storageBackend string) error {
+	if strings.TrimSpace(evaluatedKey) == "" {
+		return fmt.Errorf("the evaluated cache key is empty")
+	}
+	if strings.Contains(evaluatedKey, ",") {
+		return fmt.Errorf("commas are not allowed in the cache key, but the evaluated key contains one: %s", evaluatedKey)
+	}
+	if !isCustomBackend(storageBackend) && len(evaluatedKey) > maxKeyLength {
+		step.logger.Warnf("The evaluated key is %d characters long, it will be truncated to the first %d characters. Keys sharing the same prefix will overwrite each other's cache.", len(evaluatedKey), maxKeyLength)
+	}
+	return nil
+}
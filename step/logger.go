@@ -0,0 +1,77 @@
+package step
+
+import (
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// prefixedLogger prefixes every log line, so that the logs of caches saved in parallel can be told apart.
+type prefixedLogger struct {
+	logger log.Logger
+	prefix string
+}
+
+func newPrefixedLogger(logger log.Logger, prefix string) log.Logger {
+	return prefixedLogger{logger: logger, prefix: prefix}
+}
+
+// args prepends the prefix to the format arguments. The prefix is not part of the format string,
+// because it could contain formatting verbs (it's derived from the cache name).
+func (l prefixedLogger) args(v []interface{}) []interface{} {
+	return append([]interface{}{l.prefix}, v...)
+}
+
+func (l prefixedLogger) Infof(format string, v ...interface{}) {
+	l.logger.Infof("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) Warnf(format string, v ...interface{}) {
+	l.logger.Warnf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) Printf(format string, v ...interface{}) {
+	l.logger.Printf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) Donef(format string, v ...interface{}) {
+	l.logger.Donef("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) Debugf(format string, v ...interface{}) {
+	l.logger.Debugf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) Errorf(format string, v ...interface{}) {
+	l.logger.Errorf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) TInfof(format string, v ...interface{}) {
+	l.logger.TInfof("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) TWarnf(format string, v ...interface{}) {
+	l.logger.TWarnf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) TPrintf(format string, v ...interface{}) {
+	l.logger.TPrintf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) TDonef(format string, v ...interface{}) {
+	l.logger.TDonef("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) TDebugf(format string, v ...interface{}) {
+	l.logger.TDebugf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) TErrorf(format string, v ...interface{}) {
+	l.logger.TErrorf("%s"+format, l.args(v)...)
+}
+
+func (l prefixedLogger) Println() {
+	l.logger.Println()
+}
+
+func (l prefixedLogger) EnableDebugLog(enable bool) {
+	l.logger.EnableDebugLog(enable)
+}
@@ -0,0 +1,31 @@
+package step
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+func TestPrefixedLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{name: "plain prefix", prefix: "[npm] ", want: "[npm] saved 3 files"},
+		{name: "prefix with formatting verbs", prefix: "[100%s %d] ", want: "[100%s %d] saved 3 files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := newPrefixedLogger(log.NewLogger(log.WithOutput(&buf)), tt.prefix)
+
+			logger.Printf("saved %d files", 3)
+			if got := strings.TrimSpace(buf.String()); got != tt.want {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package step
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const cacheSaveResultsOutputKey = "BITRISE_CACHE_SAVE_RESULTS"
+
+const defaultCachesParallel = 2
+
+// cacheEntry is one item of the `caches` input.
+type cacheEntry struct {
+	Name             string   `json:"name"`
+	Key              string   `json:"key"`
+	Paths            []string `json:"paths"`
+	ExcludePaths     []string `json:"exclude_paths"`
+	IsKeyUnique      bool     `json:"is_key_unique"`
+	CompressionLevel int      `json:"compression_level"`
+}
+
+type cacheEntryResult struct {
+	Name            string `json:"name"`
+	Key             string `json:"key"`
+	Saved           bool   `json:"saved"`
+	SkipReason      string `json:"skip_reason,omitempty"`
+	ArchiveSize     int64  `json:"archive_size,omitempty"`
+	ArchiveChecksum string `json:"archive_checksum,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+func parseCacheEntries(caches string) ([]cacheEntry, error) {
+	var entries []cacheEntry
+	if err := json.Unmarshal([]byte(caches), &entries); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no caches are defined")
+	}
+
+	for i, entry := range entries {
+		if entry.Name == "" {
+			entries[i].Name = fmt.Sprintf("cache %d", i+1)
+		}
+		if strings.TrimSpace(entry.Key) == "" {
+			return nil, fmt.Errorf("%s: key is required", entries[i].Name)
+		}
+		if len(entry.Paths) == 0 {
+			return nil, fmt.Errorf("%s: paths are required", entries[i].Name)
+		}
+		if entry.CompressionLevel != 0 && (entry.CompressionLevel < 1 || entry.CompressionLevel > 19) {
+			return nil, fmt.Errorf("%s: compression level should be between 1 and 19", entries[i].Name)
+		}
+	}
+	return entries, nil
+}
+
+// input returns the step inputs with the cache specific inputs replaced by the entry's values.
+func (e cacheEntry) input(stepInput Input) Input {
+	input := stepInput
+	input.Key = e.Key
+	input.Paths = strings.Join(e.Paths, "\n")
+	input.ExcludePaths = strings.Join(e.ExcludePaths, "\n")
+	input.IsKeyUnique = e.IsKeyUnique
+	if e.CompressionLevel != 0 {
+		input.CompressionLevel = e.CompressionLevel
+	}
+	return input
+}
+
+// runMultiple saves the caches listed in the `caches` input in parallel. A failing cache doesn't stop
+// saving the others, but the step fails at the end.
+func (step SaveCacheStep) runMultiple(input Input) error {
+	entries, err := parseCacheEntries(input.Caches)
+	if err != nil {
+		return fmt.Errorf("failed to parse caches input: %w", err)
+	}
+
+	parallel := input.CachesParallel
+	if parallel == 0 {
+		parallel = defaultCachesParallel
+	}
+	step.logger.Printf("Saving %d caches, %d at a time", len(entries), parallel)
+
+	results := make([]cacheEntryResult, len(entries))
+	dryRunResults := make([]dryRunResult, len(entries))
+	semaphore := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry cacheEntry) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i], dryRunResults[i] = step.saveEntry(input, entry)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	failed := step.logMultipleResults(results)
+
+	if input.DryRun {
+		if err := step.exportDryRunResult(dryRunResults); err != nil {
+			return err
+		}
+	} else {
+		output, err := json.Marshal(results)
+		if err != nil {
+			return fmt.Errorf("failed to encode results: %w", err)
+		}
+		if err := step.exporter.ExportOutputNoExpand(cacheSaveResultsOutputKey, string(output)); err != nil {
+			return fmt.Errorf("failed to export %s: %w", cacheSaveResultsOutputKey, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d caches failed", failed, len(entries))
+	}
+	return nil
+}
+
+// saveEntry saves a single item of the caches input, or runs its dry run. Both results carry the error of a failed entry.
+func (step SaveCacheStep) saveEntry(input Input, entry cacheEntry) (cacheEntryResult, dryRunResult) {
+	entryStep := step
+	entryStep.logger = newPrefixedLogger(step.logger, fmt.Sprintf("[%s] ", entry.Name))
+	entryInput := entry.input(input)
+
+	result := cacheEntryResult{Name: entry.Name}
+	failedDryRun := dryRunResult{Name: entry.Name}
+	paths, err := entryStep.evaluatePaths(entryInput.Paths, entryInput.ExcludePaths, entryInput.ExcludeSensitiveDirs)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to evaluate paths: %s", err)
+		failedDryRun.Error = result.Error
+		return result, failedDryRun
+	}
+
+	if input.DryRun {
+		report, err := entryStep.dryRun(entryInput, paths)
+		if err != nil {
+			result.Error = err.Error()
+			failedDryRun.Error = result.Error
+			return result, failedDryRun
+		}
+		report.Name = entry.Name
+		return result, report
+	}
+
+	saveResult, err := entryStep.save(entryInput, paths)
+	if err != nil {
+		result.Error = err.Error()
+		return result, failedDryRun
+	}
+	result.Key = saveResult.Key
+	result.Saved = saveResult.Saved
+	if !saveResult.Saved {
+		result.SkipReason = saveResult.SkipReason.String()
+	}
+	result.ArchiveSize = saveResult.ArchiveSize
+	result.ArchiveChecksum = saveResult.ArchiveChecksum
+	return result, failedDryRun
+}
+
+func (step SaveCacheStep) logMultipleResults(results []cacheEntryResult) int {
+	failed := 0
+	step.logger.Println()
+	step.logger.Infof("Results:")
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			failed++
+			step.logger.Errorf("- %s: failed: %s", result.Name, result.Error)
+		case result.Saved:
+			step.logger.Donef("- %s: saved with key %s", result.Name, result.Key)
+		case result.SkipReason != "":
+			step.logger.Printf("- %s: skipped (%s)", result.Name, result.SkipReason)
+		default:
+			step.logger.Printf("- %s: done", result.Name)
+		}
+	}
+	return failed
+}
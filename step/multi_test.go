@@ -0,0 +1,92 @@
+package step
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCacheEntries(t *testing.T) {
+	tests := []struct {
+		name      string
+		caches    string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "named and unnamed entries",
+			caches:    `[{"name":"npm","key":"npm-key","paths":["node_modules"]},{"key":"gradle-key","paths":["~/.gradle"],"compression_level":19}]`,
+			wantNames: []string{"npm", "cache 2"},
+		},
+		{name: "invalid JSON", caches: `{"key":"npm-key"}`, wantErr: "invalid JSON"},
+		{name: "empty list", caches: `[]`, wantErr: "no caches are defined"},
+		{name: "missing key", caches: `[{"name":"npm","key":" ","paths":["node_modules"]}]`, wantErr: "npm: key is required"},
+		{name: "missing paths", caches: `[{"key":"npm-key"}]`, wantErr: "cache 1: paths are required"},
+		{name: "invalid compression level", caches: `[{"key":"npm-key","paths":["node_modules"],"compression_level":20}]`, wantErr: "compression level should be between 1 and 19"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseCacheEntries(tt.caches)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestSaveEntryDryRun(t *testing.T) {
+	root := createFixture(t, []string{"node_modules/a.js"})
+	paths := []string{filepath.Join(root, "node_modules")}
+
+	tests := []struct {
+		name      string
+		entry     cacheEntry
+		wantError string
+		wantKey   string
+	}{
+		{name: "valid entry", entry: cacheEntry{Name: "npm", Key: "npm-key", Paths: paths}, wantKey: "npm-key"},
+		{name: "invalid key template", entry: cacheEntry{Name: "npm", Key: "npm-{{ .OS ", Paths: paths}, wantError: "failed to evaluate key template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := newTestStep()
+			step.envRepo = mapEnvRepository{}
+
+			result, report := step.saveEntry(Input{DryRun: true, PRPolicy: prPolicyAllow}, tt.entry)
+			if report.Name != tt.entry.Name {
+				t.Errorf("report name = %s, want %s", report.Name, tt.entry.Name)
+			}
+			if report.Key != tt.wantKey {
+				t.Errorf("report key = %s, want %s", report.Key, tt.wantKey)
+			}
+			if !strings.Contains(report.Error, tt.wantError) || (tt.wantError == "") != (report.Error == "") {
+				t.Errorf("report error = %q, want %q", report.Error, tt.wantError)
+			}
+			if result.Error != report.Error {
+				t.Errorf("result error = %q, want the report error %q", result.Error, report.Error)
+			}
+
+			output, err := json.Marshal(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasErrorField := strings.Contains(string(output), `"error":`); hasErrorField != (tt.wantError != "") {
+				t.Errorf("unexpected JSON report: %s", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-steplib/bitrise-step-save-cache/storage"
+)
+
+const (
+	overwritePolicyAlways    = "always"
+	overwritePolicyNever     = "never"
+	overwritePolicyIfChanged = "if-changed"
+)
+
+// validateOverwritePolicy rejects the policies the storage backend can't apply.
+func validateOverwritePolicy(policy, storageBackend string) error {
+	if policy == overwritePolicyIfChanged && !isCustomBackend(storageBackend) {
+		return fmt.Errorf("overwrite_policy: %s is not supported by the %s storage backend, as it doesn't expose the checksum of stored archives. Use %s or %s instead", overwritePolicyIfChanged, storageBackendABCS, overwritePolicyNever, overwritePolicyAlways)
+	}
+	return nil
+}
+
+// createLookup returns the lookup of the selected storage backend.
+func (step SaveCacheStep) createLookup(uploader network.Uploader) storage.Lookup {
+	if lookup, ok := uploader.(storage.Lookup); ok {
+		return lookup
+	}
+	return storage.NewABCSLookup(step.envRepo.Get(abcsAPIURLEnvKey), step.envRepo.Get(abcsAccessTokenEnvKey), step.logger)
+}
+
+// memoLookup remembers the result of the last lookup, so that the overwrite policy and the preflight credentials check
+// share a single request.
+type memoLookup struct {
+	lookup storage.Lookup
+
+	done  bool
+	key   string
+	entry storage.StoredEntry
+	err   error
+}
+
+func newMemoLookup(lookup storage.Lookup) *memoLookup {
+	return &memoLookup{lookup: lookup}
+}
+
+func (l *memoLookup) Lookup(ctx context.Context, key string) (storage.StoredEntry, error) {
+	if !l.done || l.key != key {
+		l.entry, l.err = l.lookup.Lookup(ctx, key)
+		l.done, l.key = true, key
+	}
+	return l.entry, l.err
+}
+
+// checkStoredEntry applies the overwrite policy to the entry already stored for the key, before the archive is created.
+// It returns true if saving can be skipped. Otherwise, it returns the checksum of the stored archive (if it's known),
+// so that the upload can be skipped when the new archive turns out to be the same.
+func (step SaveCacheStep) checkStoredEntry(input Input, lookup storage.Lookup, evaluatedKey string) (bool, string) {
+	stored, err := lookup.Lookup(context.Background(), evaluatedKey)
+	if errors.Is(err, storage.ErrEntryNotFound) {
+		step.logger.Printf("No cache entry is stored with the key %s yet", evaluatedKey)
+		return false, ""
+	}
+	if err != nil {
+		step.logger.Warnf("Failed to check if a cache entry is stored with the key: %s", err)
+		return false, ""
+	}
+
+	if input.OverwritePolicy == overwritePolicyNever {
+		return true, ""
+	}
+	if input.IsKeyUnique {
+		// A unique key always belongs to the same cache content
+		return true, ""
+	}
+	if stored.ArchiveChecksum == "" {
+		step.logger.Printf("A cache entry is already stored with the key, but its checksum is unknown, it will be overwritten")
+		return false, ""
+	}
+	step.logger.Printf("A cache entry is already stored with the key, it will only be overwritten if the new archive is different")
+	return false, stored.ArchiveChecksum
+}
+
+// storedEntryEnvRepository exposes the checksum of the stored cache entry as if it was restored in the workflow,
+// so that the cache saver skips the upload when the new archive has the same checksum.
+type storedEntryEnvRepository struct {
+	env.Repository
+	key      string
+	checksum string
+}
+
+func (r storedEntryEnvRepository) Get(key string) string {
+	if key == cacheHitUniqueEnvVarPrefix+r.key {
+		return r.checksum
+	}
+	return r.Repository.Get(key)
+}
+
+func (r storedEntryEnvRepository) List() []string {
+	return append(r.Repository.List(), cacheHitUniqueEnvVarPrefix+r.key+"="+r.checksum)
+}
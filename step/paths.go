@@ -0,0 +1,309 @@
+package step
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const excludePrefix = "!"
+
+// Exclude patterns can split the cached directories into many paths. Above this count, the paths are passed to tar
+// in a file list, because the command line would exceed the argument list limit (which is lower on macOS).
+const maxTarPathArgs = 1000
+
+// parsePathsInput splits the multi-line `paths` and `exclude_paths` inputs into include paths and exclude patterns.
+// Lines of the `paths` input starting with `!` are treated as exclude patterns.
+func parsePathsInput(paths, excludePaths string) (includes []string, excludes []string) {
+	for _, line := range strings.Split(paths, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, excludePrefix) {
+			if pattern := strings.TrimSpace(strings.TrimPrefix(line, excludePrefix)); pattern != "" {
+				excludes = append(excludes, pattern)
+			}
+			continue
+		}
+		includes = append(includes, line)
+	}
+
+	for _, line := range strings.Split(excludePaths, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), excludePrefix))
+		if line == "" {
+			continue
+		}
+		excludes = append(excludes, line)
+	}
+
+	return includes, excludes
+}
+
+// evaluatePaths resolves the include paths and filters out the files matching the exclude patterns.
+// Sensitive directories (such as `~/.ssh`) are excluded too, if excludeSensitive is true.
+// The returned paths are absolute and exist, so they are passed to the cache saver unchanged.
+func (step SaveCacheStep) evaluatePaths(paths, excludePaths string, excludeSensitive bool) ([]string, error) {
+	includes, excludes := parsePathsInput(paths, excludePaths)
+	resolvedPaths := step.resolvePaths(includes)
+	if excludeSensitive {
+		for _, dir := range step.sensitiveExcludes(resolvedPaths) {
+			step.logger.Printf("Excluding sensitive directory: %s", dir)
+			// The directory itself, and its contents when a path inside it is cached
+			excludes = append(excludes, dir, filepath.Join(dir, "**"))
+		}
+	}
+
+	matcher := step.newExcludeMatcher(excludes)
+	if matcher.isEmpty() {
+		return resolvedPaths, nil
+	}
+
+	step.logger.Printf("Applying exclude patterns: %s", strings.Join(excludes, ", "))
+	finalPaths, err := step.excludePaths(resolvedPaths, matcher)
+	if err != nil {
+		return nil, err
+	}
+	step.logger.Debugf("Paths after applying exclude patterns: %s", strings.Join(finalPaths, ", "))
+
+	return finalPaths, nil
+}
+
+// resolvePaths expands wildcards and converts the paths to absolute paths, dropping the ones that don't exist.
+// This mirrors the path evaluation of the cache saver.
+func (step SaveCacheStep) resolvePaths(paths []string) []string {
+	var expandedPaths []string
+	for _, path := range paths {
+		if !strings.Contains(path, "*") {
+			expandedPaths = append(expandedPaths, path)
+			continue
+		}
+
+		base, pattern := doublestar.SplitPattern(path)
+		absBase, err := step.pathModifier.AbsPath(base)
+		if err != nil {
+			step.logger.Warnf("Failed to parse path %s, error: %s", base, err)
+			continue
+		}
+		matches, err := doublestar.Glob(os.DirFS(absBase), pattern, doublestar.WithNoFollow())
+		if err != nil {
+			step.logger.Warnf("Error in path pattern '%s': %s", path, err)
+			continue
+		}
+		if matches == nil {
+			step.logger.Warnf("No match for path pattern: %s", path)
+			continue
+		}
+		for _, match := range matches {
+			expandedPaths = append(expandedPaths, filepath.Join(absBase, match))
+		}
+	}
+
+	var finalPaths []string
+	for _, path := range expandedPaths {
+		absPath, err := step.pathModifier.AbsPath(path)
+		if err != nil {
+			step.logger.Warnf("Failed to parse path %s, error: %s", path, err)
+			continue
+		}
+		exists, err := step.pathChecker.IsPathExists(absPath)
+		if err != nil {
+			step.logger.Warnf("Failed to check path %s, error: %s", absPath, err)
+		}
+		if !exists {
+			step.logger.Warnf("Cache path doesn't exist: %s", path)
+			continue
+		}
+		finalPaths = append(finalPaths, absPath)
+	}
+
+	return finalPaths
+}
+
+// excludeMatcher matches absolute paths against exclude patterns.
+// Patterns without a path separator (such as `*.lock`) match the file name at any depth,
+// patterns starting with `**/` (such as `**/node_modules/.cache`) match at any depth,
+// other patterns are resolved to absolute paths and matched against the full path.
+type excludeMatcher struct {
+	namePatterns     []string
+	anywherePatterns []string
+	pathPatterns     []string
+}
+
+func (step SaveCacheStep) newExcludeMatcher(patterns []string) excludeMatcher {
+	var matcher excludeMatcher
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			matcher.namePatterns = append(matcher.namePatterns, pattern)
+			continue
+		}
+		if strings.HasPrefix(pattern, "**/") {
+			if !doublestar.ValidatePattern(pattern) {
+				step.logger.Warnf("Invalid exclude pattern: %s", pattern)
+				continue
+			}
+			matcher.anywherePatterns = append(matcher.anywherePatterns, pattern)
+			continue
+		}
+
+		absPattern, err := step.pathModifier.AbsPath(pattern)
+		if err != nil {
+			step.logger.Warnf("Failed to parse exclude pattern %s, error: %s", pattern, err)
+			continue
+		}
+		if !doublestar.ValidatePattern(absPattern) {
+			step.logger.Warnf("Invalid exclude pattern: %s", pattern)
+			continue
+		}
+		matcher.pathPatterns = append(matcher.pathPatterns, absPattern)
+	}
+	return matcher
+}
+
+func (m excludeMatcher) isEmpty() bool {
+	return len(m.namePatterns) == 0 && len(m.anywherePatterns) == 0 && len(m.pathPatterns) == 0
+}
+
+func (m excludeMatcher) match(path string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range m.namePatterns {
+		if ok, _ := doublestar.Match(pattern, name); ok {
+			return true
+		}
+	}
+	for _, pattern := range m.anywherePatterns {
+		if ok, _ := doublestar.Match(pattern, strings.TrimPrefix(path, "/")); ok {
+			return true
+		}
+	}
+	for _, pattern := range m.pathPatterns {
+		if ok, _ := doublestar.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// excludePaths returns the smallest list of paths that covers the given absolute paths without the excluded files.
+// Directories that contain excluded files are replaced with their remaining children, while untouched directories
+// are kept as a single path. Both the tar binary and the native archiver receive the same list,
+// so the archive content is identical regardless of the archiver used.
+func (step SaveCacheStep) excludePaths(paths []string, matcher excludeMatcher) ([]string, error) {
+	var finalPaths []string
+	for _, root := range paths {
+		if matcher.match(root) {
+			step.logger.Debugf("Excluded: %s", root)
+			continue
+		}
+
+		// Directories containing at least one excluded entry (at any depth)
+		splitDirs := map[string]bool{}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == root || !matcher.match(path) {
+				return nil
+			}
+
+			step.logger.Debugf("Excluded: %s", path)
+			for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+				splitDirs[dir] = true
+				if dir == root {
+					break
+				}
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		collected, err := collectRemainingPaths(root, splitDirs, matcher)
+		if err != nil {
+			return nil, err
+		}
+		finalPaths = append(finalPaths, collected...)
+	}
+
+	return finalPaths, nil
+}
+
+// collectRemainingPaths returns the path, or if it's a split directory, its remaining children. A split directory can't
+// be listed itself, as both archivers would add it recursively, with the excluded files. So its own entry (mode and
+// mtime) is not archived, and it's left out entirely when all of its content is excluded.
+func collectRemainingPaths(path string, splitDirs map[string]bool, matcher excludeMatcher) ([]string, error) {
+	if !splitDirs[path] {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var paths []string
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		if matcher.match(child) {
+			continue
+		}
+		childPaths, err := collectRemainingPaths(child, splitDirs, matcher)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, childPaths...)
+	}
+	return paths, nil
+}
+
+// tarPathList writes the paths into a NUL separated file list, read by tar via `--null -T <file>`.
+// One non-empty path is kept as a command line argument (and left out of the list), because the cache saver
+// skips creating the archive when all of its paths are empty. The returned func removes the file list.
+func tarPathList(paths []string) (argPaths []string, tarArgs []string, cleanup func(), err error) {
+	argPath := paths[0]
+	for _, path := range paths {
+		if !compression.AreAllPathsEmpty([]string{path}) {
+			argPath = path
+			break
+		}
+	}
+
+	file, err := os.CreateTemp("", "save-cache-paths-*")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create tar file list: %w", err)
+	}
+	cleanup = func() {
+		os.Remove(file.Name()) //nolint:errcheck
+	}
+
+	var list strings.Builder
+	for _, path := range paths {
+		if path == argPath {
+			continue
+		}
+		list.WriteString(path)
+		list.WriteByte(0)
+	}
+	if _, err := file.WriteString(list.String()); err != nil {
+		file.Close() //nolint:errcheck
+		cleanup()
+		return nil, nil, nil, fmt.Errorf("failed to write tar file list: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return nil, nil, nil, fmt.Errorf("failed to write tar file list: %w", err)
+	}
+
+	return []string{argPath}, []string{"--null", "-T", file.Name()}, cleanup, nil
+}
@@ -0,0 +1,254 @@
+package step
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/klauspost/compress/zstd"
+)
+
+func newTestStep() SaveCacheStep {
+	return SaveCacheStep{
+		logger:       log.NewLogger(log.WithOutput(io.Discard)),
+		pathChecker:  pathutil.NewPathChecker(),
+		pathProvider: pathutil.NewPathProvider(),
+		pathModifier: pathutil.NewPathModifier(),
+		envRepo:      env.NewRepository(),
+	}
+}
+
+// createFixture creates the files (with a trailing slash: empty directories) under a temp dir.
+func createFixture(t *testing.T, files []string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if strings.HasSuffix(file, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestEvaluatePathsExcludes(t *testing.T) {
+	root := createFixture(t, []string{
+		"cache/a/keep.txt",
+		"cache/a/x.lock",
+		"cache/b/keep.txt",
+		"cache/b/nested/keep.txt",
+		"cache/c/d/only.lock",
+		"cache/node_modules/.cache/tmp.txt",
+		"cache/node_modules/pkg/index.js",
+		"cache/skip/file.txt",
+		"other/file.txt",
+	})
+
+	tests := []struct {
+		name         string
+		excludePaths []string
+		want         []string
+	}{
+		{
+			name: "no excludes",
+			want: []string{"cache", "other"},
+		},
+		// cache/c only contains an excluded file, so it's not archived at all
+		{
+			name:         "file name pattern",
+			excludePaths: []string{"*.lock"},
+			want:         []string{"cache/a/keep.txt", "cache/b", "cache/node_modules", "cache/skip", "other"},
+		},
+		{
+			name:         "pattern at any depth",
+			excludePaths: []string{"**/node_modules/.cache"},
+			want:         []string{"cache/a", "cache/b", "cache/c", "cache/node_modules/pkg", "cache/skip", "other"},
+		},
+		{
+			name:         "absolute path pattern",
+			excludePaths: []string{filepath.Join(root, "cache/skip"), filepath.Join(root, "cache/b/**")},
+			want:         []string{"cache/a", "cache/c", "cache/node_modules", "other"},
+		},
+		{
+			name:         "excluded root",
+			excludePaths: []string{filepath.Join(root, "other")},
+			want:         []string{"cache"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := filepath.Join(root, "cache") + "\n" + filepath.Join(root, "other")
+			got, err := newTestStep().evaluatePaths(paths, strings.Join(tt.excludePaths, "\n"), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			for _, path := range tt.want {
+				want = append(want, filepath.Join(root, path))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("evaluatePaths() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEvaluatePathsSensitiveDirs(t *testing.T) {
+	home := createFixture(t, []string{
+		".ssh/id_ed25519",
+		".ssh/config",
+		".gradle/caches/file.bin",
+		"project/file.txt",
+	})
+	t.Setenv("HOME", home)
+
+	tests := []struct {
+		name             string
+		paths            []string
+		excludeSensitive bool
+		want             []string
+	}{
+		{
+			name:             "home directory",
+			paths:            []string{"~"},
+			excludeSensitive: true,
+			want:             []string{".gradle", "project"},
+		},
+		{
+			name:             "file inside a sensitive directory",
+			paths:            []string{"~/.ssh/id_ed25519", "~/.gradle"},
+			excludeSensitive: true,
+			want:             []string{".gradle"},
+		},
+		{
+			name:             "sensitive directory",
+			paths:            []string{"~/.ssh", "~/project"},
+			excludeSensitive: true,
+			want:             []string{"project"},
+		},
+		{
+			name:  "not excluded",
+			paths: []string{"~/.ssh/id_ed25519", "~/.gradle"},
+			want:  []string{".ssh/id_ed25519", ".gradle"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTestStep().evaluatePaths(strings.Join(tt.paths, "\n"), "", tt.excludeSensitive)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []string
+			for _, path := range tt.want {
+				want = append(want, filepath.Join(home, path))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("evaluatePaths() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestArchiversProduceSameContent(t *testing.T) {
+	if !compression.NewDependencyChecker(log.NewLogger(log.WithOutput(io.Discard)), env.NewRepository()).CheckDependencies() {
+		t.Skip("tar and zstd binaries are not available")
+	}
+
+	var files []string
+	for i := 0; i < maxTarPathArgs+10; i++ {
+		files = append(files, fmt.Sprintf("cache/dirs/%04d/file.txt", i))
+	}
+	files = append(files, "cache/empty/", "cache/a.lock", "cache/dirs/b.lock", "cache/keep/file.txt")
+	root := createFixture(t, files)
+
+	step := newTestStep()
+	paths, err := step.evaluatePaths(filepath.Join(root, "cache"), "*.lock", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) <= maxTarPathArgs {
+		t.Fatalf("fixture should be split into more than %d paths, got %d", maxTarPathArgs, len(paths))
+	}
+
+	nativeArchive := filepath.Join(t.TempDir(), "native.tzst")
+	native := compression.NewArchiver(step.logger, step.envRepo, &compression.ArchiveDependencyCheckerMock{
+		CheckDependenciesFunc: func() bool { return false },
+	})
+	if err := native.Compress(nativeArchive, paths, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	argPaths, listArgs, cleanup, err := tarPathList(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	binaryArchive := filepath.Join(t.TempDir(), "binary.tzst")
+	binary := compression.NewArchiver(step.logger, step.envRepo, compression.NewDependencyChecker(step.logger, step.envRepo))
+	if err := binary.Compress(binaryArchive, argPaths, 3, listArgs); err != nil {
+		t.Fatal(err)
+	}
+
+	nativeEntries := archiveEntries(t, nativeArchive)
+	binaryEntries := archiveEntries(t, binaryArchive)
+	if !reflect.DeepEqual(nativeEntries, binaryEntries) {
+		t.Errorf("archive entries differ\nnative: %v\nbinary: %v", nativeEntries, binaryEntries)
+	}
+	for _, entry := range nativeEntries {
+		if strings.HasSuffix(entry, ".lock") {
+			t.Errorf("excluded file is archived: %s", entry)
+		}
+	}
+}
+
+// archiveEntries returns the sorted entry names of a tar.zst archive, without the trailing slash of directories.
+func archiveEntries(t *testing.T, archivePath string) []string {
+	t.Helper()
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	var entries []string
+	reader := tar.NewReader(decoder)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, "/"+strings.TrimPrefix(strings.TrimSuffix(header.Name, "/"), "/"))
+	}
+	sort.Strings(entries)
+	return entries
+}
@@ -0,0 +1,156 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/bitrise-steplib/bitrise-step-save-cache/storage"
+)
+
+const (
+	preflightCheckKey         = "key"
+	preflightCheckCredentials = "credentials"
+	preflightCheckArchiveSize = "archive_size"
+	preflightCheckDiskSpace   = "disk_space"
+)
+
+// preflightError is returned when a check fails before the archive is created.
+// The check identifies the kind of the failure, the wrapped error describes it.
+type preflightError struct {
+	check string
+	err   error
+}
+
+func (e preflightError) Error() string {
+	return fmt.Sprintf("preflight check failed (%s): %s", e.check, e.err)
+}
+
+func (e preflightError) Unwrap() error {
+	return e.err
+}
+
+type preflightResult struct {
+	UncompressedSize int64
+	LargestDirs      []dirSize
+}
+
+// preflight checks everything that would otherwise only fail after the time consuming compression:
+// the evaluated key, the archiver dependencies, the storage credentials and, when the archive size is limited,
+// the free disk space for the archive.
+func (step SaveCacheStep) preflight(input Input, evaluatedKey string, paths []string, lookup storage.Lookup, maxArchiveSize int64) (preflightResult, error) {
+	step.logger.Println()
+	step.logger.Infof("Running preflight checks")
+
+	if err := step.checkKey(evaluatedKey, input.StorageBackend); err != nil {
+		return preflightResult{}, preflightError{check: preflightCheckKey, err: err}
+	}
+	step.checkDependencies(input)
+	if err := step.checkCredentials(input, lookup, evaluatedKey); err != nil {
+		return preflightResult{}, preflightError{check: preflightCheckCredentials, err: err}
+	}
+
+	var result preflightResult
+	// Estimating the size walks every cached file, which is only worth it when the archive size is limited
+	if maxArchiveSize > 0 {
+		uncompressedSize, largestDirs, err := estimateArchiveSize(paths)
+		if err != nil {
+			return preflightResult{}, preflightError{check: preflightCheckArchiveSize, err: fmt.Errorf("failed to estimate cache size: %w", err)}
+		}
+		if err := step.checkDiskSpace(uncompressedSize); err != nil {
+			return preflightResult{}, preflightError{check: preflightCheckDiskSpace, err: err}
+		}
+		result = preflightResult{UncompressedSize: uncompressedSize, LargestDirs: largestDirs}
+	}
+
+	step.logger.Donef("Preflight checks passed")
+	return result, nil
+}
+
+// checkDependencies checks whether the tar and zstd binaries are available. Without them, the archive is created
+// with the native implementation, which ignores custom tar arguments.
+func (step SaveCacheStep) checkDependencies(input Input) {
+	if step.haveTarAndZstd {
+		return
+	}
+	step.logger.Warnf("The tar and zstd binaries are not available, the archive will be created with the native implementation")
+	if strings.TrimSpace(input.CustomTarArgs) != "" {
+		step.logger.Warnf("The native implementation doesn't support custom_tar_args, they are ignored")
+	}
+}
+
+// checkCredentials makes a cheap authenticated request to the storage backend, so that invalid credentials
+// are detected before creating the archive. The Bitrise Build Cache API has no such request: its only read endpoint
+// is the restore endpoint, which hands out download URLs and counts as a restore, so only the env vars are checked.
+func (step SaveCacheStep) checkCredentials(input Input, lookup storage.Lookup, evaluatedKey string) error {
+	switch input.StorageBackend {
+	case "", storageBackendABCS:
+		for _, envKey := range []string{abcsAPIURLEnvKey, abcsAccessTokenEnvKey} {
+			if step.envRepo.Get(envKey) == "" {
+				return fmt.Errorf("the %s env var is not defined", envKey)
+			}
+		}
+		return nil
+	case storageBackendLocal:
+		return step.checkDirWritable(input.LocalStorageDir)
+	}
+
+	_, err := lookup.Lookup(context.Background(), evaluatedKey)
+	if errors.Is(err, storage.ErrUnauthorized) {
+		return err
+	}
+	if err != nil && !errors.Is(err, storage.ErrEntryNotFound) {
+		// Network errors can be transient, the upload retries them anyway
+		step.logger.Warnf("Failed to check the storage credentials: %s", err)
+	}
+	return nil
+}
+
+func (step SaveCacheStep) checkDirWritable(dir string) error {
+	absDir, err := step.pathModifier.AbsPath(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return fmt.Errorf("the storage directory can't be created: %w", err)
+	}
+	file, err := os.CreateTemp(absDir, ".preflight-*")
+	if err != nil {
+		return fmt.Errorf("the storage directory is not writable: %w", err)
+	}
+	file.Close()           //nolint:errcheck
+	os.Remove(file.Name()) //nolint:errcheck
+	return nil
+}
+
+// checkDiskSpace checks whether the archive fits into the temp dir. The uncompressed size is an upper estimate of
+// the archive size, so it only fails when even a well compressible archive wouldn't fit.
+func (step SaveCacheStep) checkDiskSpace(uncompressedSize int64) error {
+	tempDir := os.TempDir()
+	free, err := freeDiskSpace(tempDir)
+	if err != nil {
+		step.logger.Warnf("Failed to check free disk space: %s", err)
+		return nil
+	}
+
+	step.logger.Debugf("Free disk space in %s: %s, uncompressed cache size: %s", tempDir, humanSize(free), humanSize(uncompressedSize))
+
+	if free < uncompressedSize/maxExpectedCompressionRatio {
+		return fmt.Errorf("not enough free disk space in %s for the archive: %s free, %s uncompressed cache", tempDir, humanSize(free), humanSize(uncompressedSize))
+	}
+	if free < uncompressedSize {
+		step.logger.Warnf("Free disk space in %s (%s) is less than the uncompressed cache size (%s), the archive might not fit", tempDir, humanSize(free), humanSize(uncompressedSize))
+	}
+	return nil
+}
+
+func freeDiskSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
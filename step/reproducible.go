@@ -0,0 +1,49 @@
+package step
+
+import (
+	"strings"
+)
+
+// GNU tar options for creating the same archive from the same content, regardless of the file system's
+// directory order, the file timestamps and the user running the build.
+// See https://www.gnu.org/software/tar/manual/html_section/Reproducibility.html
+var gnuTarReproducibleArgs = []string{
+	"--sort=name",
+	"--mtime=@0",
+	"--owner=0",
+	"--group=0",
+	"--numeric-owner",
+	"--format=posix",
+	"--pax-option=exthdr.name=%d/PaxHeaders/%f,delete=atime,delete=ctime",
+}
+
+// BSD tar (libarchive) can't sort entries or override timestamps, only the owner can be normalized.
+var bsdTarReproducibleArgs = []string{
+	"--uid", "0",
+	"--gid", "0",
+	"--uname", "",
+	"--gname", "",
+}
+
+// reproducibleTarArgs returns the tar arguments for creating a reproducible archive with the installed tar binary.
+func (step SaveCacheStep) reproducibleTarArgs() []string {
+	if !step.haveTarAndZstd {
+		step.logger.Warnf("Reproducible archive is not supported without the tar and zstd binaries, the archive will be created with the native implementation")
+		return nil
+	}
+
+	cmd := step.commandFactory.Create("tar", []string{"--version"}, nil)
+	step.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		step.logger.Warnf("Failed to detect tar version: %s", err)
+		return nil
+	}
+
+	if strings.Contains(out, "GNU tar") {
+		return gnuTarReproducibleArgs
+	}
+
+	step.logger.Warnf("The installed tar binary doesn't support sorting entries and normalizing timestamps, only file owners are normalized")
+	return bsdTarReproducibleArgs
+}
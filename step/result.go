@@ -0,0 +1,103 @@
+package step
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/keytemplate"
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	cacheSavedOutputKey           = "BITRISE_CACHE_SAVED"
+	cacheSaveSkipReasonOutputKey  = "BITRISE_CACHE_SAVE_SKIP_REASON"
+	cacheSavedKeyOutputKey        = "BITRISE_CACHE_SAVED_KEY"
+	cacheArchiveSizeOutputKey     = "BITRISE_CACHE_ARCHIVE_SIZE"
+	cacheArchiveChecksumOutputKey = "BITRISE_CACHE_ARCHIVE_CHECKSUM"
+)
+
+type saveResult struct {
+	Saved           bool
+	SkipReason      skipReason
+	Key             string
+	ArchiveSize     int64
+	ArchiveChecksum string
+}
+
+// uploadRecorder records the parameters of the upload, as the cache saver doesn't return the details of its result.
+type uploadRecorder struct {
+	uploader network.Uploader
+	params   *network.UploadParams
+}
+
+func newUploadRecorder(uploader network.Uploader) *uploadRecorder {
+	return &uploadRecorder{uploader: uploader}
+}
+
+func (r *uploadRecorder) Upload(ctx context.Context, params network.UploadParams, logger log.Logger) error {
+	if err := r.uploader.Upload(ctx, params, logger); err != nil {
+		return err
+	}
+	r.params = &params
+	return nil
+}
+
+// result derives the result of a successful save. When nothing was uploaded, the skip reason is determined
+// the same way as the cache saver does it.
+func (r *uploadRecorder) result(step SaveCacheStep, input Input, evaluatedKey string) saveResult {
+	if r.params != nil {
+		return saveResult{
+			Saved:           true,
+			Key:             r.params.CacheKey,
+			ArchiveSize:     r.params.ArchiveSize,
+			ArchiveChecksum: r.params.ArchiveChecksum,
+		}
+	}
+
+	result := saveResult{Key: evaluatedKey, SkipReason: reasonNewArchiveChecksumMatch}
+	if canSkipSave, reason := step.canSkipSave(input.Key, evaluatedKey, input.IsKeyUnique); canSkipSave {
+		result.SkipReason = reason
+	} else if checksum, ok := step.getCacheHits()[evaluatedKey]; ok {
+		result.ArchiveChecksum = checksum
+	}
+	return result
+}
+
+// evaluateKeySilently evaluates the key template without logging, for cases when the cache saver
+// evaluates (and logs) it too.
+func (step SaveCacheStep) evaluateKeySilently(keyTemplate string) (string, error) {
+	silentLogger := log.NewLogger(log.WithOutput(io.Discard))
+	evaluatedKey, err := keytemplate.NewModel(step.envRepo, silentLogger).Evaluate(keyTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate key template: %w", err)
+	}
+	return evaluatedKey, nil
+}
+
+func (step SaveCacheStep) exportSaveResult(result saveResult) error {
+	skipReason := ""
+	if !result.Saved {
+		skipReason = result.SkipReason.String()
+	}
+	archiveSize := ""
+	if result.ArchiveSize > 0 {
+		archiveSize = strconv.FormatInt(result.ArchiveSize, 10)
+	}
+
+	outputs := []struct{ key, value string }{
+		{cacheSavedOutputKey, strconv.FormatBool(result.Saved)},
+		{cacheSaveSkipReasonOutputKey, skipReason},
+		{cacheSavedKeyOutputKey, result.Key},
+		{cacheArchiveSizeOutputKey, archiveSize},
+		{cacheArchiveChecksumOutputKey, result.ArchiveChecksum},
+	}
+	for _, output := range outputs {
+		if err := step.exporter.ExportOutputNoExpand(output.key, output.value); err != nil {
+			return fmt.Errorf("failed to export %s: %w", output.key, err)
+		}
+	}
+	return nil
+}
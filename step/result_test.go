@@ -0,0 +1,58 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+func TestUploadRecorderResult(t *testing.T) {
+	const (
+		keyTemplate  = `npm-{{ checksum "package-lock.json" }}`
+		evaluatedKey = "npm-abc"
+	)
+
+	tests := []struct {
+		name        string
+		params      *network.UploadParams
+		envRepo     env.Repository
+		isKeyUnique bool
+		want        saveResult
+	}{
+		{
+			name:    "uploaded",
+			params:  &network.UploadParams{CacheKey: evaluatedKey, ArchiveSize: 42, ArchiveChecksum: "new"},
+			envRepo: mapEnvRepository{},
+			want:    saveResult{Saved: true, Key: evaluatedKey, ArchiveSize: 42, ArchiveChecksum: "new"},
+		},
+		{
+			name:        "restored the same unique key",
+			envRepo:     mapEnvRepository{cacheHitUniqueEnvVarPrefix + evaluatedKey: "restored"},
+			isKeyUnique: true,
+			want:        saveResult{Key: evaluatedKey, SkipReason: reasonRestoreSameUniqueKey},
+		},
+		{
+			name:    "new archive matches the restored one",
+			envRepo: mapEnvRepository{cacheHitUniqueEnvVarPrefix + evaluatedKey: "restored"},
+			want:    saveResult{Key: evaluatedKey, SkipReason: reasonNewArchiveChecksumMatch, ArchiveChecksum: "restored"},
+		},
+		{
+			name:    "new archive matches the stored one",
+			envRepo: storedEntryEnvRepository{Repository: mapEnvRepository{}, key: evaluatedKey, checksum: "stored"},
+			want:    saveResult{Key: evaluatedKey, SkipReason: reasonNewArchiveChecksumMatch, ArchiveChecksum: "stored"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := newTestStep()
+			step.envRepo = tt.envRepo
+			recorder := &uploadRecorder{params: tt.params}
+
+			got := recorder.result(step, Input{Key: keyTemplate, IsKeyUnique: tt.isKeyUnique}, evaluatedKey)
+			if got != tt.want {
+				t.Errorf("result() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package step
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	secretScanOff  = "off"
+	secretScanWarn = "warn"
+	secretScanFail = "fail"
+)
+
+// sensitiveDirs are never cached, unless the `exclude_sensitive_dirs` input is turned off.
+var sensitiveDirs = []string{"~/.ssh", "~/.gnupg", "~/.aws"}
+
+// Only files up to this size are scanned for secrets, larger files are rarely configuration files.
+const maxSecretScanFileSize = 256 * 1024
+
+// credentialFile is a file that commonly contains credentials. If contentPattern is nil, the file is reported based
+// on its name, otherwise only if the pattern matches one of its lines.
+type credentialFile struct {
+	name           string
+	contentPattern *regexp.Regexp
+	description    string
+}
+
+var credentialFiles = []credentialFile{
+	{".npmrc", regexp.MustCompile(`(_authToken|_auth|_password)\s*=\s*[^\s$]`), "npm auth token"},
+	{".yarnrc.yml", regexp.MustCompile(`npmAuthToken:\s*["']?[^\s"'$]`), "Yarn auth token"},
+	{".netrc", regexp.MustCompile(`\bpassword\s+\S`), "netrc password"},
+	{"_netrc", regexp.MustCompile(`\bpassword\s+\S`), "netrc password"},
+	{".git-credentials", regexp.MustCompile(`://[^:/\s]+:[^@\s]+@`), "Git credentials"},
+	{".pypirc", regexp.MustCompile(`^\s*password\s*[=:]\s*\S`), "PyPI password"},
+	{"gradle.properties", regexp.MustCompile(`(?i)^\s*[\w.\-]*(password|secret|token|apikey|api_key)[\w.\-]*\s*[=:]\s*[^\s$]`), "Gradle property with a credential"},
+	{"settings.xml", regexp.MustCompile(`<password>\s*[^\s<$]`), "Maven server password"},
+	{".dockercfg", regexp.MustCompile(`"auth"\s*:\s*"[^"]+"`), "Docker registry credentials"},
+	{"id_rsa", nil, "SSH private key"},
+	{"id_dsa", nil, "SSH private key"},
+	{"id_ecdsa", nil, "SSH private key"},
+	{"id_ed25519", nil, "SSH private key"},
+}
+
+// Files with these extensions are scanned for token patterns, in addition to the credential files.
+var secretScanExtensions = map[string]bool{
+	".properties": true, ".env": true, ".json": true, ".yml": true, ".yaml": true, ".xml": true,
+	".conf": true, ".cfg": true, ".ini": true, ".toml": true, ".pem": true, ".key": true,
+}
+
+type tokenPattern struct {
+	pattern     *regexp.Regexp
+	description string
+}
+
+var tokenPatterns = []tokenPattern{
+	{regexp.MustCompile(`-----BEGIN ((RSA|EC|DSA|OPENSSH|ENCRYPTED) )?PRIVATE KEY-----`), "private key"},
+	{regexp.MustCompile(`\b(ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36}\b|\bgithub_pat_[A-Za-z0-9_]{82}\b`), "GitHub token"},
+	{regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`), "AWS access key ID"},
+	{regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), "Slack token"},
+	{regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`), "npm token"},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`), "Google API key"},
+}
+
+// Assignments of high-entropy values to credential-like names, such as `apiToken = "..."`
+var secretAssignmentPattern = regexp.MustCompile(`(?i)(token|secret|password|passwd|api[_-]?key)[\w.\-]*["']?\s*[=:]\s*["']?([A-Za-z0-9+/=_\-]{20,})`)
+
+const minSecretEntropy = 3.5
+
+type secretFinding struct {
+	Path        string
+	Line        int
+	Description string
+}
+
+func (f secretFinding) String() string {
+	if f.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", f.Path, f.Line, f.Description)
+	}
+	return fmt.Sprintf("%s: %s", f.Path, f.Description)
+}
+
+// sensitiveExcludes returns the sensitive directories that are inside (or contain) the cached paths,
+// so that they can be excluded. Paths unrelated to them don't need to be walked for excluding them.
+func (step SaveCacheStep) sensitiveExcludes(paths []string) []string {
+	var excludes []string
+	for _, dir := range sensitiveDirs {
+		absDir, err := step.pathModifier.AbsPath(dir)
+		if err != nil {
+			continue
+		}
+		if exists, err := step.pathChecker.IsDirExists(absDir); err != nil || !exists {
+			continue
+		}
+		for _, path := range paths {
+			if isSubPath(absDir, path) || isSubPath(path, absDir) {
+				excludes = append(excludes, absDir)
+				break
+			}
+		}
+	}
+	return excludes
+}
+
+func isSubPath(path, parent string) bool {
+	return path == parent || strings.HasPrefix(path, strings.TrimSuffix(parent, "/")+"/")
+}
+
+// scanSecrets scans the cached paths for credential files and token patterns before creating the archive.
+// In fail mode, it returns an error if anything is found.
+func (step SaveCacheStep) scanSecrets(mode string, paths []string) error {
+	if mode == secretScanOff {
+		return nil
+	}
+
+	step.logger.Println()
+	step.logger.Infof("Scanning cached paths for secrets")
+	var findings []secretFinding
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if mode == secretScanFail {
+					return err
+				}
+				// An unreadable entry shouldn't fail the step when the findings are only reported
+				step.logger.Warnf("Failed to scan %s for secrets: %s", path, err)
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			fileFindings, err := scanFile(path)
+			if err != nil {
+				step.logger.Debugf("Failed to scan %s: %s", path, err)
+				return nil
+			}
+			findings = append(findings, fileFindings...)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan %s for secrets: %w", root, err)
+		}
+	}
+
+	if len(findings) == 0 {
+		step.logger.Donef("No secrets found")
+		return nil
+	}
+
+	for _, finding := range findings {
+		step.logger.Warnf("- %s", finding)
+	}
+	message := fmt.Sprintf("Found %d potential secret(s) in the cached paths. Exclude these files with the exclude_paths input, or remove the secrets from them.", len(findings))
+	if mode == secretScanFail {
+		return fmt.Errorf("%s", message)
+	}
+	step.logger.Warnf(message)
+	return nil
+}
+
+func scanFile(path string) ([]secretFinding, error) {
+	name := filepath.Base(path)
+	var rule *credentialFile
+	for i := range credentialFiles {
+		if credentialFiles[i].name == name {
+			rule = &credentialFiles[i]
+			break
+		}
+	}
+	if rule != nil && rule.contentPattern == nil {
+		return []secretFinding{{Path: path, Description: rule.description}}, nil
+	}
+	if rule == nil && !secretScanExtensions[filepath.Ext(name)] && !strings.HasPrefix(name, ".env") {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxSecretScanFileSize {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(content, 0) != -1 {
+		// Binary file
+		return nil, nil
+	}
+
+	var findings []secretFinding
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSecretScanFileSize)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if description := scanLine(scanner.Text(), rule); description != "" {
+			findings = append(findings, secretFinding{Path: path, Line: lineNumber, Description: description})
+		}
+	}
+	return findings, scanner.Err()
+}
+
+// scanLine returns the description of the secret found in the line, or an empty string. The secret itself is never
+// returned, so it doesn't end up in the build log.
+func scanLine(line string, rule *credentialFile) string {
+	if rule != nil && rule.contentPattern.MatchString(line) {
+		return rule.description
+	}
+	for _, token := range tokenPatterns {
+		if token.pattern.MatchString(line) {
+			return token.description
+		}
+	}
+	for _, match := range secretAssignmentPattern.FindAllStringSubmatch(line, -1) {
+		if shannonEntropy(match[2]) >= minSecretEntropy {
+			return "high-entropy value assigned to " + strings.ToLower(match[1])
+		}
+	}
+	return ""
+}
+
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,128 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/docker/go-units"
+)
+
+const (
+	archiveSizeActionFail = "fail"
+	archiveSizeActionSkip = "skip"
+)
+
+// The uncompressed size is only considered clearly over the limit if even this compression ratio couldn't
+// bring it under the limit. Cache content rarely compresses better than this.
+const maxExpectedCompressionRatio = 10
+
+const largestDirsReportCount = 10
+
+var errArchiveTooLarge = errors.New("cache archive is larger than the max_archive_size limit")
+
+type dirSize struct {
+	Path      string
+	SizeBytes int64
+}
+
+func parseMaxArchiveSize(size string) (int64, error) {
+	if strings.TrimSpace(size) == "" {
+		return 0, nil
+	}
+	limit, err := units.FromHumanSize(strings.TrimSpace(size))
+	if err != nil {
+		return 0, fmt.Errorf("invalid max_archive_size: %w", err)
+	}
+	if limit <= 0 {
+		return 0, fmt.Errorf("invalid max_archive_size: %s", size)
+	}
+	return limit, nil
+}
+
+// checkArchiveSizeEstimate compares the uncompressed size of the cache to the limit before the archive is created,
+// and returns errArchiveTooLarge if the archive is clearly going to be over the limit.
+func (step SaveCacheStep) checkArchiveSizeEstimate(limit, uncompressedSize int64) error {
+	step.logger.Printf("Uncompressed cache size: %s (limit of the compressed archive: %s)", humanSize(uncompressedSize), humanSize(limit))
+	if uncompressedSize > limit*maxExpectedCompressionRatio {
+		return fmt.Errorf("%w: the uncompressed cache size (%s) is more than %dx the limit (%s)", errArchiveTooLarge, humanSize(uncompressedSize), maxExpectedCompressionRatio, humanSize(limit))
+	}
+	return nil
+}
+
+// estimateArchiveSize returns the total size of the files and the largest top-level entries of the paths.
+func estimateArchiveSize(paths []string) (int64, []dirSize, error) {
+	var total int64
+	var sizes []dirSize
+	for _, root := range paths {
+		info, err := os.Lstat(root)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+			sizes = append(sizes, dirSize{Path: root, SizeBytes: info.Size()})
+			continue
+		}
+
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, entry := range entries {
+			stats, err := collectPathStats(filepath.Join(root, entry.Name()))
+			if err != nil {
+				return 0, nil, err
+			}
+			total += stats.SizeBytes
+			sizes = append(sizes, dirSize{Path: stats.Path, SizeBytes: stats.SizeBytes})
+		}
+	}
+
+	sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].SizeBytes > sizes[j].SizeBytes })
+	if len(sizes) > largestDirsReportCount {
+		sizes = sizes[:largestDirsReportCount]
+	}
+	return total, sizes, nil
+}
+
+// archiveTooLarge reports the largest directories, and fails or skips saving based on the max_archive_size_action input.
+func (step SaveCacheStep) archiveTooLarge(input Input, evaluatedKey string, largestDirs []dirSize, err error) (saveResult, error) {
+	step.logger.Println()
+	if len(largestDirs) > 0 {
+		step.logger.Printf("Largest cached paths (uncompressed):")
+		for _, dir := range largestDirs {
+			step.logger.Printf("- %s: %s", dir.Path, humanSize(dir.SizeBytes))
+		}
+	}
+
+	if input.MaxArchiveSizeAction != archiveSizeActionSkip {
+		return saveResult{}, err
+	}
+	step.logger.Warnf("%s", err)
+	step.logger.Warnf("Skipping cache save, reason: %s", reasonArchiveTooLarge.description())
+	return saveResult{Key: evaluatedKey, SkipReason: reasonArchiveTooLarge}, nil
+}
+
+// sizeLimitUploader rejects archives over the size limit before uploading them.
+type sizeLimitUploader struct {
+	uploader network.Uploader
+	limit    int64
+}
+
+func (u sizeLimitUploader) Upload(ctx context.Context, params network.UploadParams, logger log.Logger) error {
+	if params.ArchiveSize > u.limit {
+		return fmt.Errorf("%w: the archive size (%s) is over the limit (%s)", errArchiveTooLarge, humanSize(params.ArchiveSize), humanSize(u.limit))
+	}
+	return u.uploader.Upload(ctx, params, logger)
+}
+
+func humanSize(size int64) string {
+	return units.HumanSizeWithPrecision(float64(size), 3)
+}
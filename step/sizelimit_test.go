@@ -0,0 +1,183 @@
+package step
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+func TestParseMaxArchiveSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: " 2GB ", want: 2_000_000_000},
+		{size: "500MB", want: 500_000_000},
+		{size: "1000", want: 1000},
+		{size: "0", wantErr: true},
+		{size: "-1GB", wantErr: true},
+		{size: "large", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMaxArchiveSize(tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMaxArchiveSize(%q) error = %v, want error: %t", tt.size, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMaxArchiveSize(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateArchiveSize(t *testing.T) {
+	root := createFixture(t, []string{"cache/small/a", "cache/large/b", "cache/large/nested/c", "file"})
+	if err := os.WriteFile(filepath.Join(root, "cache/large/b"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	total, largest, err := estimateArchiveSize([]string{filepath.Join(root, "cache"), filepath.Join(root, "file")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture files contain their own relative path, except for the 1000 byte one
+	wantLarge := int64(1000 + len("cache/large/nested/c"))
+	wantTotal := wantLarge + int64(len("cache/small/a")+len("file"))
+	if total != wantTotal {
+		t.Errorf("total = %d, want %d", total, wantTotal)
+	}
+	want := []dirSize{
+		{Path: filepath.Join(root, "cache/large"), SizeBytes: wantLarge},
+		{Path: filepath.Join(root, "cache/small"), SizeBytes: int64(len("cache/small/a"))},
+		{Path: filepath.Join(root, "file"), SizeBytes: int64(len("file"))},
+	}
+	if len(largest) != len(want) {
+		t.Fatalf("largest = %v, want %v", largest, want)
+	}
+	for i := range want {
+		if largest[i] != want[i] {
+			t.Errorf("largest[%d] = %v, want %v", i, largest[i], want[i])
+		}
+	}
+
+	if _, _, err := estimateArchiveSize([]string{filepath.Join(root, "missing")}); err == nil {
+		t.Errorf("expected an error for a missing path")
+	}
+}
+
+func TestCheckArchiveSizeEstimate(t *testing.T) {
+	step := newTestStep()
+	if err := step.checkArchiveSizeEstimate(100, 100*maxExpectedCompressionRatio); err != nil {
+		t.Errorf("unexpected error at the compression ratio: %s", err)
+	}
+	if err := step.checkArchiveSizeEstimate(100, 100*maxExpectedCompressionRatio+1); !errors.Is(err, errArchiveTooLarge) {
+		t.Errorf("error = %v, want %v", err, errArchiveTooLarge)
+	}
+}
+
+type fakeUploader struct {
+	uploaded bool
+}
+
+func (u *fakeUploader) Upload(context.Context, network.UploadParams, log.Logger) error {
+	u.uploaded = true
+	return nil
+}
+
+func TestSizeLimitUploader(t *testing.T) {
+	tests := []struct {
+		name         string
+		archiveSize  int64
+		wantErr      error
+		wantUploaded bool
+	}{
+		{name: "under the limit", archiveSize: 99, wantUploaded: true},
+		{name: "at the limit", archiveSize: 100, wantUploaded: true},
+		{name: "over the limit", archiveSize: 101, wantErr: errArchiveTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &fakeUploader{}
+			uploader := sizeLimitUploader{uploader: inner, limit: 100}
+
+			err := uploader.Upload(context.Background(), network.UploadParams{ArchiveSize: tt.archiveSize}, newTestStep().logger)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("Upload() error = %v, want %v", err, tt.wantErr)
+			}
+			if inner.uploaded != tt.wantUploaded {
+				t.Errorf("uploaded = %t, want %t", inner.uploaded, tt.wantUploaded)
+			}
+		})
+	}
+}
+
+func TestSaveArchiveSizeLimit(t *testing.T) {
+	// Random content doesn't compress, so the archive is about as large as the cached file
+	content := make([]byte, 2000)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		limit   string
+		action  string
+		wantErr string
+	}{
+		{name: "estimate over the limit, fail", limit: "100", action: archiveSizeActionFail, wantErr: "the uncompressed cache size"},
+		{name: "estimate over the limit, skip", limit: "100", action: archiveSizeActionSkip},
+		// The archive is created, the error is returned by the uploader through the cache saver
+		{name: "archive over the limit, fail", limit: "1000", action: archiveSizeActionFail, wantErr: "the archive size"},
+		{name: "archive over the limit, skip", limit: "1000", action: archiveSizeActionSkip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := createFixture(t, []string{"cache/"})
+			if err := os.WriteFile(filepath.Join(root, "cache/data"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			storageDir := filepath.Join(root, "storage")
+
+			step := newTestStep()
+			step.envRepo = mapEnvRepository{"ANALYTICS_DISABLED": "true"}
+			input := Input{
+				Key:                  "key",
+				CompressionLevel:     1,
+				StorageBackend:       storageBackendLocal,
+				LocalStorageDir:      storageDir,
+				OverwritePolicy:      overwritePolicyAlways,
+				PRPolicy:             prPolicyAllow,
+				SecretScan:           secretScanOff,
+				MaxArchiveSize:       tt.limit,
+				MaxArchiveSizeAction: tt.action,
+			}
+
+			result, err := step.save(input, []string{filepath.Join(root, "cache")})
+			if tt.wantErr != "" {
+				if !errors.Is(err, errArchiveTooLarge) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("save() error = %v, want %v with %q", err, errArchiveTooLarge, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Saved || result.SkipReason != reasonArchiveTooLarge || result.Key != "key" {
+					t.Errorf("save() = %+v, want a skip with reason %s", result, reasonArchiveTooLarge)
+				}
+			}
+
+			if entries, _ := os.ReadDir(storageDir); len(entries) > 0 {
+				t.Errorf("the archive was stored: %v", entries)
+			}
+		})
+	}
+}
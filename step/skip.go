@@ -0,0 +1,125 @@
+package step
+
+import (
+	"strings"
+)
+
+// We need this prefix because there could be multiple restore steps in one workflow with multiple cache keys
+const cacheHitUniqueEnvVarPrefix = "BITRISE_CACHE_HIT__"
+
+// skipReason mirrors the skip reasons of the cache saver, so that the step can predict and report its decisions.
+// The string values match the ones reported by the saver, except for the ones only detected by the step.
+type skipReason int
+
+const (
+	reasonKeyNotDynamic skipReason = iota
+	reasonNoRestore
+	reasonRestoreSameUniqueKey
+	reasonRestoreSameKeyNotUnique
+	reasonNoRestoreThisKey
+	reasonNewArchiveChecksumMatch
+	reasonEmptyPaths
+	reasonKeyAlreadyStored
+	reasonBranchNotAllowed
+	reasonPullRequest
+	reasonArchiveTooLarge
+)
+
+func (r skipReason) String() string {
+	switch r {
+	case reasonKeyNotDynamic:
+		return "key_not_dynamic"
+	case reasonNoRestore:
+		return "no_restore_found"
+	case reasonRestoreSameUniqueKey:
+		return "restore_same_unique_key"
+	case reasonRestoreSameKeyNotUnique:
+		return "restore_same_key_not_unique"
+	case reasonNoRestoreThisKey:
+		return "no_restore_with_this_key"
+	case reasonNewArchiveChecksumMatch:
+		return "new_archive_checksum_match"
+	case reasonEmptyPaths:
+		return "empty_paths"
+	case reasonKeyAlreadyStored:
+		return "key_already_stored"
+	case reasonBranchNotAllowed:
+		return "branch_not_allowed"
+	case reasonPullRequest:
+		return "pull_request"
+	case reasonArchiveTooLarge:
+		return "archive_too_large"
+	default:
+		return "unknown"
+	}
+}
+
+func (r skipReason) description() string {
+	switch r {
+	case reasonKeyNotDynamic:
+		return "key is not dynamic; the expectation is that the same key is used for saving different cache contents over and over"
+	case reasonNoRestore:
+		return "no cache was restored in the workflow, creating a new cache entry"
+	case reasonRestoreSameUniqueKey:
+		return "a cache with the same key was restored in the workflow, new cache would have the same content"
+	case reasonRestoreSameKeyNotUnique:
+		return "a cache with the same key was restored in the workflow, but contents might have changed since then"
+	case reasonNoRestoreThisKey:
+		return "there was no cache restore in the workflow with this key, but was for other(s)"
+	case reasonNewArchiveChecksumMatch:
+		return "new cache archive is the same as the restored one"
+	case reasonEmptyPaths:
+		return "the provided paths are all empty"
+	case reasonKeyAlreadyStored:
+		return "a cache entry is already stored with this key, and the overwrite policy doesn't require replacing it"
+	case reasonBranchNotAllowed:
+		return "the branch of the build is not allowed to save caches"
+	case reasonPullRequest:
+		return "pull request builds are not allowed to save caches"
+	case reasonArchiveTooLarge:
+		return "the cache archive is larger than the size limit"
+	default:
+		return "unrecognized skipReason"
+	}
+}
+
+// canSkipSave predicts the decision of the cache saver before creating the archive.
+func (step SaveCacheStep) canSkipSave(keyTemplate, evaluatedKey string, isKeyUnique bool) (bool, skipReason) {
+	if keyTemplate == evaluatedKey {
+		return false, reasonKeyNotDynamic
+	}
+
+	cacheHits := step.getCacheHits()
+	if len(cacheHits) == 0 {
+		return false, reasonNoRestore
+	}
+
+	if _, ok := cacheHits[evaluatedKey]; ok {
+		if isKeyUnique {
+			return true, reasonRestoreSameUniqueKey
+		}
+		return false, reasonRestoreSameKeyNotUnique
+	}
+
+	return false, reasonNoRestoreThisKey
+}
+
+// Returns cache hit information exposed by previous restore cache steps.
+// The returned map's key is the restored cache key, and the value is the checksum of the cache archive
+func (step SaveCacheStep) getCacheHits() map[string]string {
+	cacheHits := map[string]string{}
+	for _, e := range step.envRepo.List() {
+		envParts := strings.SplitN(e, "=", 2)
+		if len(envParts) < 2 {
+			continue
+		}
+		envKey := envParts[0]
+		envValue := envParts[1]
+
+		if strings.HasPrefix(envKey, cacheHitUniqueEnvVarPrefix) {
+			cacheKey := strings.TrimPrefix(envKey, cacheHitUniqueEnvVarPrefix)
+			cacheHits[cacheKey] = envValue
+		}
+	}
+	return cacheHits
+}
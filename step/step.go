@@ -1,10 +1,13 @@
 package step
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/bitrise-io/go-steputils/v2/cache"
+	"github.com/bitrise-io/go-steputils/v2/cache/compression"
+	"github.com/bitrise-io/go-steputils/v2/export"
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
@@ -13,12 +16,31 @@ import (
 )
 
 type Input struct {
-	Verbose          bool   `env:"verbose,required"`
-	Key              string `env:"key,required"`
-	Paths            string `env:"paths,required"`
-	IsKeyUnique      bool   `env:"is_key_unique"`
-	CompressionLevel int    `env:"compression_level,range[1..19]"`
-	CustomTarArgs    string `env:"custom_tar_args"`
+	Verbose              bool   `env:"verbose,required"`
+	Key                  string `env:"key"`
+	Paths                string `env:"paths"`
+	ExcludePaths         string `env:"exclude_paths"`
+	IsKeyUnique          bool   `env:"is_key_unique"`
+	CompressionLevel     int    `env:"compression_level,range[1..19]"`
+	CustomTarArgs        string `env:"custom_tar_args"`
+	StorageBackend       string `env:"storage_backend,opt[abcs,local,s3]"`
+	LocalStorageDir      string `env:"local_storage_dir"`
+	S3Bucket             string `env:"s3_bucket"`
+	S3Endpoint           string `env:"s3_endpoint"`
+	S3Region             string `env:"s3_region"`
+	S3Prefix             string `env:"s3_prefix"`
+	Reproducible         bool   `env:"reproducible_archive"`
+	OverwritePolicy      string `env:"overwrite_policy,opt[always,never,if-changed]"`
+	AllowedBranches      string `env:"allowed_branches"`
+	PRPolicy             string `env:"pr_policy,opt[allow,skip,namespace]"`
+	SecretScan           string `env:"secret_scan,opt[off,warn,fail]"`
+	ExcludeSensitiveDirs bool   `env:"exclude_sensitive_dirs"`
+	MaxArchiveSize       string `env:"max_archive_size"`
+	MaxArchiveSizeAction string `env:"max_archive_size_action,opt[fail,skip]"`
+	StrictKey            bool   `env:"strict_key"`
+	DryRun               bool   `env:"dry_run"`
+	Caches               string `env:"caches"`
+	CachesParallel       int    `env:"caches_parallel,range[1..10]"`
 }
 
 type SaveCacheStep struct {
@@ -29,9 +51,13 @@ type SaveCacheStep struct {
 	pathProvider   pathutil.PathProvider
 	pathModifier   pathutil.PathModifier
 	envRepo        env.Repository
+	exporter       export.Exporter
+
+	// haveTarAndZstd tells whether the archive is created by the tar and zstd binaries, or the native implementation
+	haveTarAndZstd bool
 }
 
-func New(logger log.Logger, inputParser stepconf.InputParser, commandFactory command.Factory, pathChecker pathutil.PathChecker, pathProvider pathutil.PathProvider, pathModifier pathutil.PathModifier, envRepo env.Repository) SaveCacheStep {
+func New(logger log.Logger, inputParser stepconf.InputParser, commandFactory command.Factory, pathChecker pathutil.PathChecker, pathProvider pathutil.PathProvider, pathModifier pathutil.PathModifier, envRepo env.Repository, exporter export.Exporter) SaveCacheStep {
 	return SaveCacheStep{
 		logger:         logger,
 		inputParser:    inputParser,
@@ -40,6 +66,7 @@ func New(logger log.Logger, inputParser stepconf.InputParser, commandFactory com
 		pathProvider:   pathProvider,
 		pathModifier:   pathModifier,
 		envRepo:        envRepo,
+		exporter:       exporter,
 	}
 }
 
@@ -53,14 +80,156 @@ func (step SaveCacheStep) Run() error {
 
 	step.logger.EnableDebugLog(input.Verbose)
 
-	saver := cache.NewSaver(step.envRepo, step.logger, step.pathProvider, step.pathModifier, step.pathChecker, nil)
-	return saver.Save(cache.SaveCacheInput{
+	// Checked once for all caches, the cache saver checks it again when creating the archive
+	step.haveTarAndZstd = compression.NewDependencyChecker(step.logger, step.envRepo).CheckDependencies()
+
+	if err := validateBranchPatterns(input.AllowedBranches); err != nil {
+		return err
+	}
+	if err := validateOverwritePolicy(input.OverwritePolicy, input.StorageBackend); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(input.Caches) != "" {
+		return step.runMultiple(input)
+	}
+	if strings.TrimSpace(input.Key) == "" || strings.TrimSpace(input.Paths) == "" {
+		return fmt.Errorf("the key and paths inputs are required, unless the caches input is set")
+	}
+
+	paths, err := step.evaluatePaths(input.Paths, input.ExcludePaths, input.ExcludeSensitiveDirs)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate paths: %w", err)
+	}
+
+	if input.DryRun {
+		result, err := step.dryRun(input, paths)
+		if err != nil {
+			return err
+		}
+		return step.exportDryRunResult(result)
+	}
+
+	result, err := step.save(input, paths)
+	if err != nil {
+		return err
+	}
+
+	return step.exportSaveResult(result)
+}
+
+func (step SaveCacheStep) save(input Input, paths []string) (saveResult, error) {
+	keyTemplate, allowed, reason := step.guardBranch(input)
+	if allowed {
+		input.Key = keyTemplate
+	}
+	// Evaluated once here, as checksum functions walk the file tree. The cache saver evaluates it again.
+	evaluatedKey, err := step.evaluateKeySilently(input.Key)
+	if err != nil {
+		return saveResult{}, err
+	}
+	if !allowed {
+		step.logger.Donef("Cache save can be skipped, reason: %s", reason.description())
+		return saveResult{Key: evaluatedKey, SkipReason: reason}, nil
+	}
+
+	if input.StrictKey {
+		if err := step.validateKeyStrictly(input.Key); err != nil {
+			return saveResult{}, err
+		}
+	}
+
+	if err := step.scanSecrets(input.SecretScan, paths); err != nil {
+		return saveResult{}, err
+	}
+
+	if compression.AreAllPathsEmpty(paths) {
+		// The cache saver would exit the process in this case, before the outputs could be exported
+		step.logger.Warnf("The provided paths are all empty, skipping compression and upload.")
+		return saveResult{Key: evaluatedKey, SkipReason: reasonEmptyPaths}, nil
+	}
+
+	maxArchiveSize, err := parseMaxArchiveSize(input.MaxArchiveSize)
+	if err != nil {
+		return saveResult{}, err
+	}
+
+	uploader, err := step.createUploader(input)
+	if err != nil {
+		return saveResult{}, err
+	}
+
+	lookup := newMemoLookup(step.createLookup(uploader))
+	// Checked before preflight, as saving can be skipped without estimating the cache size
+	if input.OverwritePolicy == overwritePolicyNever || input.OverwritePolicy == overwritePolicyIfChanged {
+		skip, storedChecksum := step.checkStoredEntry(input, lookup, evaluatedKey)
+		if skip {
+			step.logger.Donef("Cache save can be skipped, reason: %s", reasonKeyAlreadyStored.description())
+			return saveResult{Key: evaluatedKey, SkipReason: reasonKeyAlreadyStored}, nil
+		}
+		if storedChecksum != "" {
+			step.envRepo = storedEntryEnvRepository{Repository: step.envRepo, key: evaluatedKey, checksum: storedChecksum}
+		}
+	}
+
+	var preflight preflightResult
+	// Nothing is compressed when the cache saver can skip saving
+	if canSkipSave, _ := step.canSkipSave(input.Key, evaluatedKey, input.IsKeyUnique); !canSkipSave {
+		preflight, err = step.preflight(input, evaluatedKey, paths, lookup, maxArchiveSize)
+		if err != nil {
+			return saveResult{}, err
+		}
+		if maxArchiveSize > 0 {
+			if err := step.checkArchiveSizeEstimate(maxArchiveSize, preflight.UncompressedSize); err != nil {
+				return step.archiveTooLarge(input, evaluatedKey, preflight.LargestDirs, err)
+			}
+		}
+	}
+
+	if maxArchiveSize > 0 {
+		uploader = sizeLimitUploader{uploader: uploader, limit: maxArchiveSize}
+	}
+
+	envRepo := step.envRepo
+	if isCustomBackend(input.StorageBackend) {
+		envRepo = customBackendEnvRepository{Repository: step.envRepo}
+	}
+	recorder := newUploadRecorder(uploader)
+
+	customTarArgs := strings.Fields(input.CustomTarArgs)
+	if input.Reproducible {
+		customTarArgs = append(step.reproducibleTarArgs(), customTarArgs...)
+	}
+
+	savePaths := paths
+	// The native archiver doesn't have a command line, it can take any number of paths
+	if len(paths) > maxTarPathArgs && step.haveTarAndZstd {
+		argPaths, listArgs, cleanup, err := tarPathList(paths)
+		if err != nil {
+			return saveResult{}, err
+		}
+		defer cleanup()
+		step.logger.Printf("Passing %d paths to tar in a file list", len(paths))
+		savePaths = argPaths
+		customTarArgs = append(customTarArgs, listArgs...)
+	}
+
+	saver := cache.NewSaver(envRepo, step.logger, step.pathProvider, step.pathModifier, step.pathChecker, recorder)
+	err = saver.Save(cache.SaveCacheInput{
 		StepId:           "save-cache",
 		Verbose:          input.Verbose,
 		Key:              input.Key,
-		Paths:            strings.Split(input.Paths, "\n"),
+		Paths:            savePaths,
 		IsKeyUnique:      input.IsKeyUnique,
 		CompressionLevel: input.CompressionLevel,
-		CustomTarArgs:    strings.Fields(input.CustomTarArgs),
+		CustomTarArgs:    customTarArgs,
 	})
+	if errors.Is(err, errArchiveTooLarge) {
+		return step.archiveTooLarge(input, evaluatedKey, preflight.LargestDirs, err)
+	}
+	if err != nil {
+		return saveResult{}, err
+	}
+
+	return recorder.result(step, input, evaluatedKey), nil
 }
@@ -0,0 +1,95 @@
+package step
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-steplib/bitrise-step-save-cache/storage"
+)
+
+const (
+	storageBackendABCS  = "abcs"
+	storageBackendLocal = "local"
+	storageBackendS3    = "s3"
+)
+
+const (
+	abcsAPIURLEnvKey      = "BITRISEIO_ABCS_API_URL"
+	abcsAccessTokenEnvKey = "BITRISEIO_BITRISE_SERVICES_ACCESS_TOKEN"
+
+	awsAccessKeyIDEnvKey     = "AWS_ACCESS_KEY_ID"
+	awsSecretAccessKeyEnvKey = "AWS_SECRET_ACCESS_KEY"
+	awsSessionTokenEnvKey    = "AWS_SESSION_TOKEN"
+)
+
+// createUploader returns the uploader of the selected storage backend.
+func (step SaveCacheStep) createUploader(input Input) (network.Uploader, error) {
+	switch input.StorageBackend {
+	case "", storageBackendABCS:
+		return network.DefaultUploader{}, nil
+	case storageBackendLocal:
+		if input.LocalStorageDir == "" {
+			return nil, fmt.Errorf("local_storage_dir input is required for the %s storage backend", storageBackendLocal)
+		}
+		dir, err := step.pathModifier.AbsPath(input.LocalStorageDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local_storage_dir: %w", err)
+		}
+		step.logger.Printf("Using local storage backend: %s", dir)
+		return storage.NewLocalUploader(dir), nil
+	case storageBackendS3:
+		config, err := step.createS3Config(input)
+		if err != nil {
+			return nil, err
+		}
+		step.logger.Printf("Using S3 storage backend, bucket: %s", config.Bucket)
+		return storage.NewS3Uploader(config, step.logger), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", input.StorageBackend)
+	}
+}
+
+func (step SaveCacheStep) createS3Config(input Input) (storage.S3Config, error) {
+	if input.S3Bucket == "" {
+		return storage.S3Config{}, fmt.Errorf("s3_bucket input is required for the %s storage backend", storageBackendS3)
+	}
+	credentials := storage.S3Credentials{
+		AccessKeyID:     step.envRepo.Get(awsAccessKeyIDEnvKey),
+		SecretAccessKey: step.envRepo.Get(awsSecretAccessKeyEnvKey),
+		SessionToken:    step.envRepo.Get(awsSessionTokenEnvKey),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return storage.S3Config{}, fmt.Errorf("the secrets '%s' and '%s' are required for the %s storage backend", awsAccessKeyIDEnvKey, awsSecretAccessKeyEnvKey, storageBackendS3)
+	}
+
+	region := input.S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return storage.S3Config{
+		Endpoint:    input.S3Endpoint,
+		Region:      region,
+		Bucket:      input.S3Bucket,
+		Prefix:      input.S3Prefix,
+		Credentials: credentials,
+	}, nil
+}
+
+func isCustomBackend(backend string) bool {
+	return backend != "" && backend != storageBackendABCS
+}
+
+// customBackendEnvRepository provides placeholder Bitrise Build Cache credentials when a custom storage backend is
+// used. The cache saver requires these env vars to be set, even though a custom uploader doesn't use them.
+type customBackendEnvRepository struct {
+	env.Repository
+}
+
+func (r customBackendEnvRepository) Get(key string) string {
+	value := r.Repository.Get(key)
+	if value == "" && (key == abcsAPIURLEnvKey || key == abcsAccessTokenEnvKey) {
+		return "unused"
+	}
+	return value
+}
@@ -0,0 +1,56 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/keytemplate"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// Functions of the key template, only their names are needed for parsing.
+var keyTemplateFuncs = template.FuncMap{
+	"getenv":   func(string) string { return "" },
+	"checksum": func(...string) string { return "" },
+}
+
+// validateKeyStrictly evaluates each action of the key template separately, and returns an error naming the first
+// one that evaluates to an empty value, such as a checksum matching no files, an unset env var or an undefined
+// template variable. Otherwise these would silently collapse the key into one shared by unrelated builds.
+func (step SaveCacheStep) validateKeyStrictly(keyTemplate string) error {
+	// Parsed the same way as the key template model does it, so that the text/template builtins (such as printf) are known
+	tmpl, err := template.New("key").Funcs(keyTemplateFuncs).Parse(keyTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid key template: %w", err)
+	}
+	tree := tmpl.Tree
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+
+	silentLogger := log.NewLogger(log.WithOutput(io.Discard))
+	model := keytemplate.NewModel(step.envRepo, silentLogger)
+	for _, node := range tree.Root.Nodes {
+		action, ok := node.(*parse.ActionNode)
+		if !ok || len(action.Pipe.Decl) > 0 {
+			// Control structures and variable declarations can be empty by design
+			continue
+		}
+
+		part := action.String()
+		value, err := model.Evaluate(part)
+		if err != nil {
+			// Actions referring to template variables can't be evaluated on their own
+			step.logger.Debugf("Strict key check: can't evaluate %s separately: %s", part, err)
+			continue
+		}
+		step.logger.Debugf("Strict key check: %s = %s", part, value)
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("strict key: the key template part %s evaluated to an empty value", part)
+		}
+	}
+	return nil
+}
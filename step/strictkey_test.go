@@ -0,0 +1,42 @@
+package step
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateKeyStrictly(t *testing.T) {
+	envs := mapEnvRepository{gitBranchEnvKey: "main", "CACHE_VERSION": "2"}
+
+	tests := []struct {
+		name        string
+		keyTemplate string
+		wantErr     string
+	}{
+		{name: "static key", keyTemplate: "npm-cache"},
+		{name: "all parts set", keyTemplate: `npm-{{ .OS }}-{{ .Branch }}-{{ getenv "CACHE_VERSION" }}`},
+		{name: "text/template builtin", keyTemplate: `npm-{{ printf "%s-%s" .OS .Branch }}`},
+		{name: "control structure", keyTemplate: `npm-{{ if .Workflow }}{{ .Workflow }}{{ end }}`},
+		{name: "empty template variable", keyTemplate: "npm-{{ .Workflow }}", wantErr: "{{.Workflow}} evaluated to an empty value"},
+		{name: "unset env var", keyTemplate: `npm-{{ getenv "MISSING" }}`, wantErr: `{{getenv "MISSING"}} evaluated to an empty value`},
+		{name: "empty builtin result", keyTemplate: `npm-{{ printf "%s" .Workflow }}`, wantErr: "evaluated to an empty value"},
+		{name: "invalid template", keyTemplate: "npm-{{ .OS ", wantErr: "invalid key template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := newTestStep()
+			step.envRepo = envs
+
+			err := step.validateKeyStrictly(tt.keyTemplate)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
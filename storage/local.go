@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	archiveExtension  = ".tzst"
+	metadataExtension = ".json"
+)
+
+// ArchiveMetadata is stored next to the cache archive and describes its content.
+type ArchiveMetadata struct {
+	CacheKey        string    `json:"cache_key"`
+	ArchiveChecksum string    `json:"archive_checksum"`
+	ArchiveSize     int64     `json:"archive_size_in_bytes"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// LocalUploader stores cache archives in a directory, such as a volume shared between self-hosted runners.
+// Each cache entry consists of a `<key>.tzst` archive and a `<key>.json` metadata file.
+type LocalUploader struct {
+	dir string
+}
+
+// NewLocalUploader ...
+func NewLocalUploader(dir string) LocalUploader {
+	return LocalUploader{dir: dir}
+}
+
+// Upload copies the cache archive into the storage directory and writes its metadata file.
+func (u LocalUploader) Upload(ctx context.Context, params network.UploadParams, logger log.Logger) error {
+	if strings.Contains(params.CacheKey, ",") {
+		return fmt.Errorf("validating cache key: commas are not allowed in key")
+	}
+
+	if err := os.MkdirAll(u.dir, 0755); err != nil {
+		return fmt.Errorf("create storage directory: %w", err)
+	}
+
+	archivePath := ArchivePath(u.dir, params.CacheKey)
+	logger.Debugf("Copying archive to %s", archivePath)
+	if err := copyFileAtomic(ctx, params.ArchivePath, archivePath); err != nil {
+		return fmt.Errorf("store archive: %w", err)
+	}
+
+	metadata := ArchiveMetadata{
+		CacheKey:        params.CacheKey,
+		ArchiveChecksum: params.ArchiveChecksum,
+		ArchiveSize:     params.ArchiveSize,
+		CreatedAt:       time.Now().UTC(),
+	}
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode metadata: %w", err)
+	}
+	if err := writeFileAtomic(MetadataPath(u.dir, params.CacheKey), content); err != nil {
+		return fmt.Errorf("store metadata: %w", err)
+	}
+
+	return nil
+}
+
+// ArchivePath returns the path of the cache archive belonging to the key in the storage directory.
+func ArchivePath(dir, key string) string {
+	return filepath.Join(dir, fileName(key)+archiveExtension)
+}
+
+// MetadataPath returns the path of the metadata file belonging to the key in the storage directory.
+func MetadataPath(dir, key string) string {
+	return filepath.Join(dir, fileName(key)+metadataExtension)
+}
+
+// ReadMetadata reads the metadata of a stored cache entry.
+func ReadMetadata(dir, key string) (ArchiveMetadata, error) {
+	content, err := os.ReadFile(MetadataPath(dir, key))
+	if err != nil {
+		return ArchiveMetadata{}, err
+	}
+	var metadata ArchiveMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return ArchiveMetadata{}, fmt.Errorf("decode metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// fileName escapes the cache key, so that keys containing path separators map to a single file.
+func fileName(key string) string {
+	return url.PathEscape(key)
+}
+
+// copyFileAtomic copies the file next to the destination and renames it, so that readers of
+// the shared directory never see a partially written archive.
+func copyFileAtomic(ctx context.Context, src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close() //nolint:errcheck
+
+	return writeAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, contextReader{ctx: ctx, r: source})
+		return err
+	})
+}
+
+func writeFileAtomic(dst string, content []byte) error {
+	return writeAtomic(dst, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+func writeAtomic(dst string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if err := write(tmp); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// contextReader stops reading once the context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+func TestLocalUploaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		wantFile string
+	}{
+		{name: "simple key", key: "npm-cache-abc", wantFile: "npm-cache-abc"},
+		{name: "key with slashes", key: "feature/login/npm-cache", wantFile: "feature%2Flogin%2Fnpm-cache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "storage")
+			archivePath, content := writeTestArchive(t, 1024)
+			uploader := NewLocalUploader(dir)
+
+			if _, err := uploader.Lookup(context.Background(), tt.key); !errors.Is(err, ErrEntryNotFound) {
+				t.Fatalf("Lookup() before upload error = %v, want %v", err, ErrEntryNotFound)
+			}
+
+			err := uploader.Upload(context.Background(), network.UploadParams{
+				ArchivePath:     archivePath,
+				ArchiveSize:     int64(len(content)),
+				ArchiveChecksum: "checksum",
+				CacheKey:        tt.key,
+			}, log.NewLogger(log.WithOutput(io.Discard)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := os.ReadFile(filepath.Join(dir, tt.wantFile+archiveExtension))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, content) {
+				t.Errorf("stored archive differs from the uploaded one")
+			}
+			metadata, err := ReadMetadata(dir, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.CacheKey != tt.key || metadata.ArchiveSize != int64(len(content)) || metadata.ArchiveChecksum != "checksum" {
+				t.Errorf("unexpected metadata: %+v", metadata)
+			}
+
+			entry, err := uploader.Lookup(context.Background(), tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.ArchiveChecksum != "checksum" {
+				t.Errorf("Lookup() checksum = %s, want checksum", entry.ArchiveChecksum)
+			}
+			assertDirEntries(t, dir, []string{tt.wantFile + metadataExtension, tt.wantFile + archiveExtension})
+		})
+	}
+}
+
+func TestLocalUploaderRejectsComma(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "storage")
+	archivePath, _ := writeTestArchive(t, 16)
+
+	err := NewLocalUploader(dir).Upload(context.Background(), network.UploadParams{ArchivePath: archivePath, CacheKey: "a,b"}, log.NewLogger(log.WithOutput(io.Discard)))
+	if err == nil || !strings.Contains(err.Error(), "commas are not allowed") {
+		t.Fatalf("expected comma error, got: %v", err)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("storage directory was created: %v", err)
+	}
+}
+
+func TestLocalUploaderCancelledUpload(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "storage")
+	archivePath, _ := writeTestArchive(t, 1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := NewLocalUploader(dir).Upload(ctx, network.UploadParams{ArchivePath: archivePath, CacheKey: "key"}, log.NewLogger(log.WithOutput(io.Discard)))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Upload() error = %v, want %v", err, context.Canceled)
+	}
+	assertDirEntries(t, dir, nil)
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "file")
+	if err := os.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := func(w io.Writer) error {
+		if _, err := w.Write([]byte("partial")); err != nil {
+			return err
+		}
+		return errors.New("write failed")
+	}
+	if err := writeAtomic(dst, failing); err == nil {
+		t.Fatal("expected an error")
+	}
+	assertDirEntries(t, dir, []string{"file"})
+	if content, _ := os.ReadFile(dst); string(content) != "old" {
+		t.Errorf("content after a failed write = %s, want old", content)
+	}
+
+	if err := writeFileAtomic(dst, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	assertDirEntries(t, dir, []string{"file"})
+	if content, _ := os.ReadFile(dst); string(content) != "new" {
+		t.Errorf("content = %s, want new", content)
+	}
+}
+
+func assertDirEntries(t *testing.T, dir string, want []string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("directory entries = %v, want %v", names, want)
+	}
+}
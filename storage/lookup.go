@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// ErrEntryNotFound is returned by Lookup when no cache entry is stored for the key.
+var ErrEntryNotFound = errors.New("no cache entry is stored for the key")
+
+// ErrUnauthorized is returned by Lookup when the storage backend rejects the credentials.
+var ErrUnauthorized = errors.New("the storage backend rejected the credentials")
+
+// StoredEntry describes a cache entry that is already stored for a key.
+type StoredEntry struct {
+	// ArchiveChecksum is empty when the storage backend doesn't expose the checksum of stored archives.
+	ArchiveChecksum string
+}
+
+// Lookup checks whether a cache entry is stored for a key, without downloading it.
+type Lookup interface {
+	Lookup(ctx context.Context, key string) (StoredEntry, error)
+}
+
+// Lookup ...
+func (u LocalUploader) Lookup(_ context.Context, key string) (StoredEntry, error) {
+	metadata, err := ReadMetadata(u.dir, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return StoredEntry{}, ErrEntryNotFound
+	}
+	if err != nil {
+		return StoredEntry{}, err
+	}
+	return StoredEntry{ArchiveChecksum: metadata.ArchiveChecksum}, nil
+}
+
+// Lookup ...
+func (u S3Uploader) Lookup(ctx context.Context, key string) (StoredEntry, error) {
+	content, err := u.client.getObject(ctx, u.client.objectName(key, metadataExtension))
+	if err != nil {
+		return StoredEntry{}, err
+	}
+	var metadata ArchiveMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return StoredEntry{}, fmt.Errorf("decode metadata: %w", err)
+	}
+	return StoredEntry{ArchiveChecksum: metadata.ArchiveChecksum}, nil
+}
+
+// The Bitrise Build Cache API truncates longer keys
+const abcsMaxKeyLength = 512
+
+// ABCSLookup looks up cache entries in the Bitrise Build Cache using the restore endpoint of its API.
+// The API client of go-steputils/v2/cache/network is not exported, so this sends the same request as its restore call.
+// The API doesn't expose the checksum of stored archives. Each lookup counts as a restore of the key, as the endpoint
+// hands out a download URL.
+type ABCSLookup struct {
+	httpClient  *retryablehttp.Client
+	baseURL     string
+	accessToken string
+	logger      log.Logger
+}
+
+// NewABCSLookup ...
+func NewABCSLookup(baseURL, accessToken string, logger log.Logger) ABCSLookup {
+	return ABCSLookup{
+		httpClient:  retryhttp.NewClient(logger),
+		baseURL:     baseURL,
+		accessToken: accessToken,
+		logger:      logger,
+	}
+}
+
+type abcsRestoreResponse struct {
+	MatchedKey string `json:"matched_cache_key"`
+}
+
+// Lookup ...
+func (l ABCSLookup) Lookup(ctx context.Context, key string) (StoredEntry, error) {
+	if len(key) > abcsMaxKeyLength {
+		key = key[:abcsMaxKeyLength]
+	}
+	apiURL := fmt.Sprintf("%s/restore?cache_keys=%s", l.baseURL, url.QueryEscape(key))
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return StoredEntry{}, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", l.accessToken))
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return StoredEntry{}, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			l.logger.Printf(err.Error())
+		}
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return StoredEntry{}, ErrEntryNotFound
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return StoredEntry{}, fmt.Errorf("%w: HTTP %d", ErrUnauthorized, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorResp, _ := io.ReadAll(resp.Body)
+		return StoredEntry{}, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errorResp)
+	}
+
+	var response abcsRestoreResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return StoredEntry{}, fmt.Errorf("decode response: %w", err)
+	}
+	// The restore endpoint also matches keys by prefix
+	if response.MatchedKey != key {
+		return StoredEntry{}, ErrEntryNotFound
+	}
+	return StoredEntry{}, nil
+}
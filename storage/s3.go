@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/cache/network"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/retryhttp"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+const (
+	s3MinPartSize  = 8 * 1024 * 1024
+	s3MaxPartSize  = 100 * 1024 * 1024
+	s3MaxPartCount = 10000
+)
+
+// S3Config describes an S3 or S3-compatible (such as MinIO) bucket.
+type S3Config struct {
+	// Endpoint is the base URL of the storage service. Defaults to the AWS S3 endpoint of the region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the object names, for example `bitrise-cache/`.
+	Prefix      string
+	Credentials S3Credentials
+}
+
+// S3Uploader uploads cache archives to an S3-compatible bucket using the multipart upload protocol.
+// Objects are addressed path-style (`<endpoint>/<bucket>/<object>`), which is supported by AWS and self-hosted storages.
+// Each cache entry consists of a `<prefix><key>.tzst` archive and a `<prefix><key>.json` metadata object.
+type S3Uploader struct {
+	client s3Client
+}
+
+// NewS3Uploader ...
+func NewS3Uploader(config S3Config, logger log.Logger) S3Uploader {
+	return S3Uploader{client: newS3Client(config, retryhttp.NewClient(logger), logger)}
+}
+
+// Upload uploads the cache archive in parts, then stores its metadata.
+func (u S3Uploader) Upload(ctx context.Context, params network.UploadParams, logger log.Logger) error {
+	if strings.Contains(params.CacheKey, ",") {
+		return fmt.Errorf("validating cache key: commas are not allowed in key")
+	}
+
+	archiveObject := u.client.objectName(params.CacheKey, archiveExtension)
+	if err := u.uploadMultipart(ctx, archiveObject, params.ArchivePath, params.ArchiveSize, logger); err != nil {
+		return fmt.Errorf("upload archive: %w", err)
+	}
+
+	metadata := ArchiveMetadata{
+		CacheKey:        params.CacheKey,
+		ArchiveChecksum: params.ArchiveChecksum,
+		ArchiveSize:     params.ArchiveSize,
+		CreatedAt:       time.Now().UTC(),
+	}
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode metadata: %w", err)
+	}
+	metadataObject := u.client.objectName(params.CacheKey, metadataExtension)
+	if err := u.client.putObject(ctx, metadataObject, content, "application/json"); err != nil {
+		return fmt.Errorf("upload metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (u S3Uploader) uploadMultipart(ctx context.Context, object, archivePath string, archiveSize int64, logger log.Logger) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("open archive file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	partSize := partSizeFor(archiveSize, int64(partConcurrency()))
+	logger.Debugf("Using multipart upload for file (%d bytes) with part size %d bytes", archiveSize, partSize)
+
+	uploadID, err := u.client.createMultipartUpload(ctx, object)
+	if err != nil {
+		return fmt.Errorf("create multipart upload: %w", err)
+	}
+	logger.Debugf("Multipart Upload ID: %s", uploadID)
+
+	etags, err := u.uploadParts(ctx, file, archiveSize, partSize, object, uploadID, logger)
+	if err != nil {
+		logger.Warnf("Upload failed, aborting multipart upload %s", uploadID)
+		if abortErr := u.client.abortMultipartUpload(context.Background(), object, uploadID); abortErr != nil {
+			logger.Errorf("Failed to abort multipart upload: %v", abortErr)
+		}
+		return fmt.Errorf("upload parts: %w", err)
+	}
+
+	if err := u.client.completeMultipartUpload(ctx, object, uploadID, etags); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
+	}
+	logger.Debugf("Multipart upload completed")
+
+	return nil
+}
+
+type partResult struct {
+	index int
+	etag  string
+	err   error
+}
+
+func (u S3Uploader) uploadParts(ctx context.Context, file io.ReaderAt, size, partSize int64, object, uploadID string, logger log.Logger) ([]string, error) {
+	numParts := int((size + partSize - 1) / partSize)
+	if numParts == 0 {
+		// An empty archive is still uploaded as a single (empty) part
+		numParts = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan partResult, numParts)
+	semaphore := make(chan struct{}, partConcurrency())
+	var wg sync.WaitGroup
+	for i := 0; i < numParts; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if err := ctx.Err(); err != nil {
+				results <- partResult{index: index, err: err}
+				return
+			}
+
+			offset := int64(index) * partSize
+			length := partSize
+			if offset+length > size {
+				length = size - offset
+			}
+			// The part is streamed from the file, so that the concurrent uploads don't hold the parts in memory
+			part := io.NewSectionReader(file, offset, length)
+
+			start := time.Now()
+			etag, err := u.client.uploadPart(ctx, object, uploadID, index+1, part)
+			if err == nil {
+				logger.Infof("Part %d/%d uploaded successfully in %v", index+1, numParts, time.Since(start).Round(time.Second))
+			}
+			results <- partResult{index: index, etag: etag, err: err}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	etags := make([]string, numParts)
+	for result := range results {
+		if result.err != nil {
+			cancel()
+			return nil, fmt.Errorf("upload part %d: %w", result.index+1, result.err)
+		}
+		etags[result.index] = result.etag
+	}
+
+	return etags, nil
+}
+
+// partSizeFor splits the archive evenly between the concurrent uploads, within the limits of the multipart protocol.
+func partSizeFor(totalSize, concurrency int64) int64 {
+	size := totalSize / concurrency
+	if size < s3MinPartSize {
+		size = s3MinPartSize
+	}
+	if size > s3MaxPartSize {
+		size = s3MaxPartSize
+	}
+	if totalSize/size >= s3MaxPartCount {
+		size = totalSize/(s3MaxPartCount-1) + 1
+	}
+	return size
+}
+
+func partConcurrency() int {
+	c := runtime.NumCPU() * 3
+	if c > 20 {
+		c = 20
+	}
+	if c < 2 {
+		c = 2
+	}
+	return c
+}
+
+type s3Client struct {
+	httpClient *retryablehttp.Client
+	config     S3Config
+	signer     signer
+	logger     log.Logger
+}
+
+func newS3Client(config S3Config, httpClient *retryablehttp.Client, logger log.Logger) s3Client {
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return s3Client{
+		httpClient: httpClient,
+		config:     config,
+		signer:     signer{credentials: config.Credentials, region: config.Region},
+		logger:     logger,
+	}
+}
+
+func (c s3Client) objectName(key, extension string) string {
+	return c.config.Prefix + key + extension
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []completePart `xml:"Part"`
+}
+
+type completePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c s3Client) createMultipartUpload(ctx context.Context, object string) (string, error) {
+	query := url.Values{"uploads": {""}}
+	resp, err := c.do(ctx, http.MethodPost, object, query, nil, map[string]string{"Content-Type": "application/zstd"})
+	if err != nil {
+		return "", err
+	}
+	defer c.closeBody(resp.Body)
+
+	var result initiateMultipartUploadResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("no upload ID in response")
+	}
+	return result.UploadID, nil
+}
+
+func (c s3Client) uploadPart(ctx context.Context, object, uploadID string, partNumber int, part io.ReadSeeker) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	resp, err := c.do(ctx, http.MethodPut, object, query, part, nil)
+	if err != nil {
+		return "", err
+	}
+	defer c.closeBody(resp.Body)
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("no ETag returned from part upload")
+	}
+	return etag, nil
+}
+
+func (c s3Client) completeMultipartUpload(ctx context.Context, object, uploadID string, etags []string) error {
+	request := completeMultipartUpload{}
+	for i, etag := range etags {
+		request.Parts = append(request.Parts, completePart{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"uploadId": {uploadID}}
+	resp, err := c.do(ctx, http.MethodPost, object, query, bytes.NewReader(body), map[string]string{"Content-Type": "application/xml"})
+	if err != nil {
+		return err
+	}
+	defer c.closeBody(resp.Body)
+
+	// The request can fail after the 200 OK status has been sent, in which case the body contains an error document
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(responseBody, []byte("<Error>")) {
+		return fmt.Errorf("complete multipart upload: %s", responseBody)
+	}
+	return nil
+}
+
+func (c s3Client) abortMultipartUpload(ctx context.Context, object, uploadID string) error {
+	query := url.Values{"uploadId": {uploadID}}
+	resp, err := c.do(ctx, http.MethodDelete, object, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.closeBody(resp.Body)
+	return nil
+}
+
+func (c s3Client) putObject(ctx context.Context, object string, data []byte, contentType string) error {
+	resp, err := c.do(ctx, http.MethodPut, object, nil, bytes.NewReader(data), map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return err
+	}
+	c.closeBody(resp.Body)
+	return nil
+}
+
+// s3Error is the error response body of S3.
+type s3Error struct {
+	Code string `xml:"Code"`
+}
+
+// getObject returns ErrEntryNotFound when the object doesn't exist, and ErrUnauthorized when the credentials are rejected.
+func (c s3Client) getObject(ctx context.Context, object string) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, object, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeBody(resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrEntryNotFound
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		var s3Err s3Error
+		// S3 returns AccessDenied instead of 404 for a missing object when the caller isn't allowed to list the bucket
+		if xml.Unmarshal(content, &s3Err) == nil && s3Err.Code == "AccessDenied" {
+			return nil, ErrEntryNotFound
+		}
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: HTTP %d: %s", ErrUnauthorized, resp.StatusCode, content)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, content)
+	}
+	return content, nil
+}
+
+// do sends a signed request and returns the response if its status code is 2xx.
+func (c s3Client) do(ctx context.Context, method, object string, query url.Values, body io.ReadSeeker, headers map[string]string) (*http.Response, error) {
+	resp, err := c.send(ctx, method, object, query, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer c.closeBody(resp.Body)
+		errorResp, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errorResp)
+	}
+	return resp, nil
+}
+
+// send sends a signed request to the object's URL. The body is read twice: once for the payload hash of the
+// signature, and once when sending it (and again on each retry).
+func (c s3Client) send(ctx context.Context, method, object string, query url.Values, body io.ReadSeeker, headers map[string]string) (*http.Response, error) {
+	rawURL := fmt.Sprintf("%s/%s/%s", c.config.Endpoint, uriEncode(c.config.Bucket, true), uriEncode(object, false))
+	if len(query) > 0 {
+		rawURL += "?" + canonicalizeQuery(query)
+	}
+
+	payloadHash, size, err := hashPayload(body)
+	if err != nil {
+		return nil, fmt.Errorf("read request body: %w", err)
+	}
+
+	var rawBody interface{}
+	if size > 0 {
+		// An empty body is sent with a zero Content-Length, a zero length reader would be sent chunked
+		rawBody = body
+	}
+	req, err := retryablehttp.NewRequestWithContext(ctx, method, rawURL, rawBody)
+	if err != nil {
+		return nil, err
+	}
+	// S3 doesn't accept chunked uploads, and the length of a generic io.ReadSeeker is unknown to the HTTP client
+	req.ContentLength = size
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	c.signer.sign(req.Request, payloadHash, time.Now())
+
+	return c.httpClient.Do(req)
+}
+
+// hashPayload returns the SHA-256 hash and the size of the body, and rewinds it.
+func hashPayload(body io.ReadSeeker) (string, int64, error) {
+	if body == nil {
+		return sha256Hex(nil), 0, nil
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func (c s3Client) closeBody(body io.ReadCloser) {
+	if err := body.Close(); err != nil {
+		c.logger.Printf(err.Error())
+	}
+}